@@ -2,8 +2,13 @@
 set GOPATH=%~dp0
 set ERRORLEVEL=0
 
+echo Generating packet marshalers...
+go generate ./src/lib/packets
 echo Building project...
 go build -i -o ./bin/account/server.exe -v ./src/account/main
 go build -i -o ./bin/game/server.exe -v ./src/game/main
 go build -i -o ./bin/web/server.exe -v ./src/web/main
+go build -i -o ./bin/tools/conquer-replay.exe -v ./src/replay/main
+go build -i -o ./bin/tools/conquer-dissect.exe -v ./src/dissect/main
+go build -i -o ./bin/tools/conquer-account.exe -v ./src/accountadmin/main
 echo Build completed.
\ No newline at end of file
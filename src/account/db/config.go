@@ -3,6 +3,7 @@ package db
 import (
 	"bufio"
 	"encoding/json"
+	"lib/network"
 	"os"
 )
 
@@ -11,7 +12,44 @@ import (
 // found in the same directory as the executable.
 var Configuration configuration
 type configuration struct {
+	// Host is the address clients connect to, unless Listeners is set.
 	Host string
+	
+	// Listeners optionally replaces Host with several addresses, each serving 
+	// the client patches of a protocol profile (the default profile if not 
+	// specified), which selects whether the password seed is sent.
+	Listeners []Listener
+	
+	// Timeout is in seconds; a value of zero disables the client's idle timeout.
+	Timeout int
+	
+	// Admission and Flood limit connections and packets from each client. Both
+	// can be changed by reloading the configuration.
+	Admission network.AdmissionConfig
+	Flood     network.FloodConfig
+	
+	// Lockout limits failed logins per account and per IP address.
+	Lockout LockoutConfig
+	
+	// Capture is the directory for session captures; an empty string disables 
+	// captures.
+	Capture string
+	
+	// LogPackets prints every packet received, for debugging.
+	LogPackets bool
+	
+	// CrashReports is the directory for crash reports.
+	CrashReports string
+	
+	// Connections from TrustedProxies must start with a PROXY protocol header.
+	TrustedProxies []string
+}
+
+// Listener is an address the account server listens on, and the name of the 
+// protocol profile for its clients (see lib/protocol).
+type Listener struct {
+	Host    string
+	Profile string
 }
 
 // Decode is called from the main function to load the server's json configuration
@@ -22,6 +60,7 @@ func (c *configuration) Decode(path string) error {
 	// Open the configuration file and read stream.	
 	file, err := os.Open(path)
 	if err != nil { return err }
+	defer file.Close()
 	reader := bufio.NewReader(file)
 	
 	// Decode the JSON file into the structure passed.
@@ -3,19 +3,57 @@ package db
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"lib/security"
 	"lib/structures"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Definitions for account names and passwords. Both are limited to the length
+// of their fields in MsgAccount, since the client can't send anything longer.
+const (
+	USERNAME_LENGTH = 16
+	PASSWORD_LENGTH = 16
+)
+
+var ErrInvalidUsername = errors.New("usernames may only contain letters, digits, " +
+	"underscores, and hyphens, up to 16 characters")
+var ErrInvalidPassword = errors.New("passwords must be 1 to 16 characters")
+var ErrAccountExists = errors.New("account already exists")
+var ErrIndexLocked = errors.New("index.json is locked by another process " +
+	"(remove index.json.lock if it's stale)")
+var ErrAccountLocked = errors.New("the account's file is locked by another " +
+	"process (remove its .lock file if it's stale)")
+
+var accountlock sync.Mutex
+
+// ValidUsername returns true if the username can be used as an account's file
+// name. Only letters, digits, underscores, and hyphens are allowed, so a
+// username can never escape the accounts directory.
+func ValidUsername(username string) bool {
+	if len(username) == 0 || len(username) > USERNAME_LENGTH { return false }
+	for _, c := range username {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') &&
+			c != '_' && c != '-' { return false }
+	}
+	return true
+}
+
 // LoadAccount reads an account file from the database given a username from the 
 // player's MsgAccount packet (sent by the client from the login screen). If no
 // account exists, the function will return false.
 func LoadAccount(acct *structures.Account, username string) bool {
+	if !ValidUsername(username) { return false }
 	
 	// Open the file and read stream.
 	file, err := os.Open(fmt.Sprintf("./accounts/%s.json", username))
 	if err != nil { return false }
+	defer file.Close()
 	reader := bufio.NewReader(file)
 	
 	// Decode the JSON file into the structure passed.
@@ -24,3 +62,157 @@ func LoadAccount(acct *structures.Account, username string) bool {
 	if err != nil { fmt.Println("failed to parse account file") }
 	return err == nil
 }
+
+// SaveAccount encodes an account to JSON and saves it to the flat-file database,
+// replacing the account's file. The account is written to a temporary file first
+// and then renamed over the old file, so an interrupted save never leaves a 
+// truncated file. Returns false if the account couldn't be saved.
+func SaveAccount(acct *structures.Account) bool {
+	if !ValidUsername(acct.Username) {
+		fmt.Printf("error: invalid username %q\n", acct.Username)
+		return false
+	}
+	accountlock.Lock()
+	defer accountlock.Unlock()
+	err := writejson(fmt.Sprintf("./accounts/%s.json", acct.Username), acct)
+	if err != nil { fmt.Println(err); return false }
+	return true
+}
+
+// UpdateAccount loads an account, calls update to change it, and saves it if 
+// update returns true. The account's file is locked while it's updated, so 
+// updates made at the same time by the account server and the account tool are
+// applied one after the other, and neither overwrites the other's changes (such 
+// as entries appended to the account's history). Returns the updated account.
+func UpdateAccount(username string, 
+	update func(acct *structures.Account) bool) (*structures.Account, error) {
+	if !ValidUsername(username) { return nil, ErrInvalidUsername }
+	unlock, err := lockfile(fmt.Sprintf("./accounts/%s.json.lock", username), 
+		ErrAccountLocked)
+	if err != nil { return nil, err }
+	defer unlock()
+	
+	// Read the account inside the lock, so the update sees the latest changes.
+	acct := new(structures.Account)
+	if !LoadAccount(acct, username) {
+		return nil, fmt.Errorf("account %s doesn't exist", username)
+	}
+	if update(acct) && !SaveAccount(acct) {
+		return nil, fmt.Errorf("failed to save account %s", acct.Username)
+	}
+	return acct, nil
+}
+
+// CreateAccount creates a new account in the flat-file database. The account's
+// identity is allocated from the AccountIdentitySeed in index.json, and its
+// password is hashed by SetPassword. The index is locked while the account is 
+// created, so accounts created at the same time by different processes never 
+// share an identity.
+func CreateAccount(acct *structures.Account, password string) error {
+	if !ValidUsername(acct.Username) { return ErrInvalidUsername }
+	if err := SetPassword(acct, password); err != nil { return err }
+	
+	// Lock the index, and check that the account doesn't already exist. Account
+	// files are matched without case, since the file system may ignore case.
+	unlock, err := lockindex()
+	if err != nil { return err }
+	defer unlock()
+	accounts, err := ListAccounts()
+	if err != nil { return err }
+	for _, account := range accounts {
+		if strings.EqualFold(account.Username, acct.Username) { return ErrAccountExists }
+	}
+	
+	// Allocate the account's identity. The index is saved before the account, so
+	// an identity is never reused, even if saving the account fails.
+	var index struct { AccountIdentitySeed uint32 }
+	file, err := os.Open("./index.json")
+	if err != nil { return err }
+	err = json.NewDecoder(bufio.NewReader(file)).Decode(&index)
+	file.Close()
+	if err != nil { return err }
+	acct.Identity = index.AccountIdentitySeed
+	index.AccountIdentitySeed++
+	if err := writejson("./index.json", index); err != nil { return err }
+	if !SaveAccount(acct) { return fmt.Errorf("failed to save account %s", acct.Username) }
+	return nil
+}
+
+// SetPassword replaces an account's password hash with a hash of the password. 
+// Passwords are hashed the same way passwords are rehashed on login.
+func SetPassword(acct *structures.Account, password string) error {
+	if len(password) == 0 || len(password) > PASSWORD_LENGTH ||
+		strings.ContainsRune(password, 0) { return ErrInvalidPassword }
+	hash, err := security.HashPassword(password)
+	if err != nil { return err }
+	acct.Password = hash
+	return nil
+}
+
+// ListAccounts loads every account in the flat-file database, sorted by identity.
+// Files which aren't named after a valid username are skipped, since they can't
+// be logged into.
+func ListAccounts() ([]*structures.Account, error) {
+	entries, err := os.ReadDir("./accounts")
+	if err != nil { return nil, err }
+	accounts := make([]*structures.Account, 0, len(entries))
+	for _, entry := range entries {
+		username, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || !ValidUsername(username) { continue }
+		account := new(structures.Account)
+		if !LoadAccount(account, username) {
+			return nil, fmt.Errorf("failed to load account %s", entry.Name())
+		}
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Identity < accounts[j].Identity
+	})
+	return accounts, nil
+}
+
+// lockindex locks index.json by creating a lock file next to it, waiting a few
+// seconds for another process to release the lock. The function returned
+// releases the lock.
+func lockindex() (func(), error) {
+	return lockfile("./index.json.lock", ErrIndexLocked)
+}
+
+// lockfile creates a lock file, waiting a few seconds for another process (or 
+// go routine) to remove it first. Returns locked if the lock file still exists.
+// The function returned removes the lock file.
+func lockfile(path string, locked error) (func(), error) {
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) { return nil, err }
+		if attempt == 50 { return nil, locked }
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// writejson encodes a value to a JSON file, indented like the hand-written files
+// in the database. The value is written to a temporary file first and then
+// renamed over the old file, so an interrupted write never leaves a truncated
+// file.
+func writejson(path string, v interface{}) error {
+	
+	// Create the temporary file for the new data.
+	file, err := os.Create(path + ".tmp")
+	if err != nil { return err }
+	
+	// Encode to the new file.
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(v)
+	if err == nil { err = writer.Flush() }
+	if cerr := file.Close(); err == nil { err = cerr }
+	if err != nil { os.Remove(path + ".tmp"); return err }
+	
+	// Replace the old file.
+	return os.Rename(path + ".tmp", path)
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"fmt"
+	"lib/structures"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// database creates an empty flat-file database in a temporary directory, and
+// changes to it for the rest of the test.
+func database(t *testing.T) string {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("accounts", 0755); err != nil { t.Fatal(err) }
+	index := []byte(`{ "AccountIdentitySeed": 1000001 }`)
+	if err := os.WriteFile("index.json", index, 0644); err != nil { t.Fatal(err) }
+	return dir
+}
+
+func TestValidUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{ "Test", true },
+		{ "a_b-9", true },
+		{ "abcdefghijklmnop", true },
+		{ "abcdefghijklmnopq", false },
+		{ "", false },
+		{ "../x", false },
+		{ "a/b", false },
+		{ `a\b`, false },
+		{ "a b", false },
+		{ "a.json", false },
+		{ "tést", false },
+	}
+	for _, test := range tests {
+		if ValidUsername(test.username) != test.valid {
+			t.Errorf("ValidUsername(%q) = %v, want %v", test.username,
+				!test.valid, test.valid)
+		}
+	}
+}
+
+// TestCreateAccount checks that accounts are created with identities from the
+// index, and that invalid and duplicate usernames are rejected without writing
+// any files.
+func TestCreateAccount(t *testing.T) {
+	dir := database(t)
+	account := &structures.Account { Username: "Test" }
+	if err := CreateAccount(account, "password"); err != nil { t.Fatal(err) }
+	if account.Identity != 1000001 { t.Fatalf("identity %d", account.Identity) }
+	second := &structures.Account { Username: "Second" }
+	if err := CreateAccount(second, "password"); err != nil { t.Fatal(err) }
+	if second.Identity != 1000002 { t.Fatalf("identity %d", second.Identity) }
+	
+	loaded := new(structures.Account)
+	if !LoadAccount(loaded, "Test") { t.Fatal("account wasn't saved") }
+	if loaded.Identity != 1000001 || loaded.Password == "password" {
+		t.Fatalf("loaded %+v", loaded)
+	}
+	
+	for _, username := range []string { "../x", "a/b", "", "abcdefghijklmnopq" } {
+		account := &structures.Account { Username: username }
+		if err := CreateAccount(account, "password"); err != ErrInvalidUsername {
+			t.Errorf("CreateAccount(%q): got %v, want %v", username, err,
+				ErrInvalidUsername)
+		}
+	}
+	if err := CreateAccount(&structures.Account { Username: "test" },
+		"password"); err != ErrAccountExists {
+		t.Errorf("duplicate account: got %v, want %v", err, ErrAccountExists)
+	}
+	if err := CreateAccount(&structures.Account { Username: "Third" },
+		"abcdefghijklmnopq"); err != ErrInvalidPassword {
+		t.Errorf("long password: got %v, want %v", err, ErrInvalidPassword)
+	}
+	
+	// Only the two accounts were written, and nothing escaped the directory.
+	accounts, _ := filepath.Glob(filepath.Join(dir, "accounts", "*"))
+	if len(accounts) != 2 { t.Fatalf("accounts directory has %v", accounts) }
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 { t.Fatalf("database directory has %v", files) }
+	if _, err := os.Stat("index.json.lock"); !os.IsNotExist(err) {
+		t.Fatalf("index lock wasn't released: %v", err)
+	}
+}
+
+// TestUpdateAccount checks that concurrent updates to an account are applied 
+// one after the other, so every status change is kept in the account's history.
+func TestUpdateAccount(t *testing.T) {
+	database(t)
+	account := &structures.Account { Username: "Test" }
+	if err := CreateAccount(account, "password"); err != nil { t.Fatal(err) }
+	
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			_, err := UpdateAccount("Test", func(a *structures.Account) bool {
+				time.Sleep(10 * time.Millisecond) // Give the updates time to overlap.
+				a.SetStatus(structures.ACCTSTATUS_LOCKED, time.Time {}, 
+					fmt.Sprint(i), 0)
+				return true
+			})
+			if err != nil { t.Error(err) }
+		}()
+	}
+	wait.Wait()
+	
+	loaded := new(structures.Account)
+	if !LoadAccount(loaded, "Test") { t.Fatal("account wasn't saved") }
+	if len(loaded.History) != 10 { 
+		t.Fatalf("history has %d entries, want 10", len(loaded.History)) 
+	}
+	reasons := make(map[string]bool)
+	for _, entry := range loaded.History { reasons[entry.Reason] = true }
+	if len(reasons) != 10 { t.Fatalf("history %+v", loaded.History) }
+	if _, err := os.Stat("accounts/Test.json.lock"); !os.IsNotExist(err) {
+		t.Fatalf("account lock wasn't released: %v", err)
+	}
+	
+	// Updates which return false aren't saved, and missing accounts fail.
+	updated, err := UpdateAccount("Test", func(a *structures.Account) bool {
+		a.Authority = 9
+		return false
+	})
+	if err != nil || updated.Authority != 9 { t.Fatalf("got %+v, %v", updated, err) }
+	if !LoadAccount(loaded, "Test") || loaded.Authority != 0 {
+		t.Fatalf("unsaved update was saved: %+v", loaded)
+	}
+	if _, err := UpdateAccount("Missing", func(*structures.Account) bool { 
+		return true 
+	}); err == nil { t.Fatal("updated a missing account") }
+	if _, err := UpdateAccount("../x", func(*structures.Account) bool { 
+		return true 
+	}); err != ErrInvalidUsername { t.Fatalf("got %v, want %v", err, 
+		ErrInvalidUsername) }
+}
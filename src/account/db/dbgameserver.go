@@ -5,22 +5,34 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"lib/backend"
 	"lib/structures"
+	"lib/threadsafe"
 	"os"
+	"sync"
 )
 
+var gameserverlock sync.Mutex
+
 // LoadGameServers loads game server forwarding information on server startup,
 // used for transferring account information and redirecting the player's client 
-// to the correct game server. Returns false on error.
+// to the correct game server. It's called again when the operator reloads the
+// server: game servers which were added are connected to, game servers which
+// were removed are disconnected, and game servers which changed are reconnected
+// with their new details. If any file can't be loaded, the current game servers
+// are kept. Returns false on error.
 func LoadGameServers() bool {
+	gameserverlock.Lock()
+	defer gameserverlock.Unlock()
+	if Kernel.GameServers == nil { Kernel.GameServers = threadsafe.NewSafeMap() }
 	
 	// Read all files from the servers directory.
 	fmt.Println("Loading game servers...")
-	Kernel.GameServers = make(map[string]*structures.GameServer)
 	files, err := ioutil.ReadDir("./servers")
 	if err != nil { fmt.Println(err); return false }
 	
 	// Read from each file.
+	servers := make(map[string]*structures.GameServer)
 	for _, f := range files {
 		file, err := os.Open(fmt.Sprintf("./servers/%s", f.Name()))
 		if err != nil { fmt.Println(err); return false }
@@ -30,11 +42,47 @@ func LoadGameServers() bool {
 		server := &structures.GameServer {}
 		decoder := json.NewDecoder(reader)
 		err = decoder.Decode(server)
-		if err != nil { fmt.Println(err); return false }
-		
-		// Add to map of available servers.
-		Kernel.GameServers[server.Name] = server
+		file.Close()
+		if err == nil { err = backend.CheckSecret([]byte(server.Secret)) }
+		if err != nil { fmt.Printf("%s: %s\n", f.Name(), err); return false }
+		if _, exists := servers[server.Name]; exists {
+			fmt.Printf("game server %s is defined more than once\n", server.Name)
+			return false
+		}
+		servers[server.Name] = server
+	}
+	
+	// Disconnect from game servers which were removed or changed.
+	for _, key := range Kernel.GameServers.Keys() {
+		current := Kernel.GameServers.Get(key).(*structures.GameServer)
+		server, exists := servers[current.Name]
+		if exists && samegameserver(server, current) {
+			delete(servers, current.Name)
+			continue
+		}
+		Kernel.GameServers.RemoveValue(key, current)
+		current.Close()
+		if !exists { fmt.Printf("Removed game server %s\n", current.Name) }
+	}
+	
+	// Add to map of available servers.
+	for _, server := range servers {
+		Kernel.GameServers.Add(server.Name, server)
 		go server.Connect()
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// GetGameServer returns the game server with the name, or nil if the game server
+// doesn't exist.
+func GetGameServer(name string) *structures.GameServer {
+	server, _ := Kernel.GameServers.Get(name).(*structures.GameServer)
+	return server
+}
+
+// samegameserver returns true if two game servers have the same details, so the
+// current game server's connection can be kept.
+func samegameserver(a, b *structures.GameServer) bool {
+	return a.Name == b.Name && a.Host == b.Host && a.Port == b.Port &&
+		a.Backend == b.Backend && a.Secret == b.Secret
+}
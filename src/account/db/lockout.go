@@ -0,0 +1,177 @@
+package db
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockoutConfig is decoded from the account server's configuration file. It
+// limits failed logins: after AccountAttempts failed logins to the same account,
+// or AddressAttempts failed logins from the same IP address, within Window
+// seconds, the account or address is locked out for Duration seconds. A zero
+// number of attempts disables that check, and a zero duration disables the 
+// lockout. A zero window counts failures over the lockout's duration instead.
+type LockoutConfig struct {
+	AccountAttempts int
+	AddressAttempts int
+	Window          int
+	Duration        int
+}
+
+// Lockout counts failed logins per account and per IP address. Addresses are
+// blocked by the lockout itself, until their block expires or an operator clears
+// it. Accounts are locked by the caller, which persists the lock to the account
+// record; the lockout only reports when an account has failed too many times.
+type Lockout struct {
+	lock      sync.Mutex
+	config    LockoutConfig
+	accounts  map[string]*failures
+	addresses map[string]*failures
+}
+
+// failures is the count of failed logins for an account or address since the
+// first failure in the current window. Until is set once an address is blocked.
+type failures struct {
+	count int
+	first time.Time
+	until time.Time
+}
+
+// NewLockout creates the login lockout from the server's configuration.
+func NewLockout(config LockoutConfig) *Lockout {
+	l := &Lockout {
+		accounts: make(map[string]*failures),
+		addresses: make(map[string]*failures),
+	}
+	l.Configure(config)
+	return l
+}
+
+// Configure replaces the lockout's thresholds. Failures already counted and
+// addresses already blocked are kept.
+func (l *Lockout) Configure(config LockoutConfig) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.config = config
+}
+
+// Duration returns how long an account or address is locked out for.
+func (l *Lockout) Duration() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return time.Duration(l.config.Duration) * time.Second
+}
+
+// Blocked returns true if logins from the remote address are blocked.
+func (l *Lockout) Blocked(addr net.Addr) bool {
+	return l.blocked(hostname(addr), time.Now())
+}
+
+// blocked returns true if logins from the host are blocked at the time.
+func (l *Lockout) blocked(host string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	entry, exists := l.addresses[host]
+	return exists && now.Before(entry.until)
+}
+
+// Fail records a failed login from the remote address to an account. The account
+// may be empty if it doesn't exist, in which case only the address is counted.
+// Locked is true if the account has reached its limit, and should be locked by
+// the caller. Blocked is true if the address has reached its limit, and is now
+// blocked. In either case, the count of failures starts over.
+func (l *Lockout) Fail(addr net.Addr, account string) (locked, blocked bool) {
+	return l.fail(hostname(addr), account, time.Now())
+}
+
+// fail records a failed login from the host to an account at the time.
+func (l *Lockout) fail(host, account string, 
+	now time.Time) (locked, blocked bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.config.Duration <= 0 { return false, false }
+	l.prune(now)
+	if l.config.AccountAttempts > 0 && account != "" {
+		entry := l.count(l.accounts, strings.ToLower(account), now)
+		if entry.count >= l.config.AccountAttempts {
+			delete(l.accounts, strings.ToLower(account))
+			locked = true
+		}
+	}
+	if l.config.AddressAttempts > 0 {
+		entry := l.count(l.addresses, host, now)
+		if entry.count >= l.config.AddressAttempts {
+			entry.count = 0
+			entry.until = now.Add(time.Duration(l.config.Duration) * time.Second)
+			blocked = true
+		}
+	}
+	return locked, blocked
+}
+
+// Unlock forgets an account's failed logins. It's called after a successful 
+// login, and when an operator clears the account's lock. The address's failures
+// are kept, so logging into another account doesn't reset the address's limit.
+func (l *Lockout) Unlock(account string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.accounts, strings.ToLower(account))
+}
+
+// Unblock clears an address's block and failed logins. Returns false if the
+// address wasn't blocked.
+func (l *Lockout) Unblock(address string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	entry, exists := l.addresses[address]
+	delete(l.addresses, address)
+	return exists && time.Now().Before(entry.until)
+}
+
+// count adds a failure to an account or address, starting a new window if the
+// previous window has passed. Must be called with the lock held.
+func (l *Lockout) count(entries map[string]*failures, key string,
+	now time.Time) *failures {
+	entry, exists := entries[key]
+	if !exists {
+		entry = new(failures)
+		entries[key] = entry
+	}
+	if entry.count == 0 || now.Sub(entry.first) > l.window() {
+		entry.count = 0
+		entry.first = now
+	}
+	entry.count++
+	return entry
+}
+
+// prune removes failures outside of the current window, and expired blocks.
+// Must be called with the lock held.
+func (l *Lockout) prune(now time.Time) {
+	window := l.window()
+	for key, entry := range l.accounts {
+		if now.Sub(entry.first) > window { delete(l.accounts, key) }
+	}
+	for key, entry := range l.addresses {
+		if now.Sub(entry.first) > window && !now.Before(entry.until) {
+			delete(l.addresses, key)
+		}
+	}
+}
+
+// window returns the duration failures are counted over. Must be called with the
+// lock held.
+func (l *Lockout) window() time.Duration {
+	if l.config.Window > 0 { return time.Duration(l.config.Window) * time.Second }
+	return time.Duration(l.config.Duration) * time.Second
+}
+
+// hostname returns the IP address of a remote address, without its port, so 
+// failures from the same address are counted together.
+func hostname(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil { return addr.String() }
+	return host
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// fails records failed logins from the host to the account, and returns whether
+// the last one locked the account or blocked the host.
+func fails(l *Lockout, host, account string, now time.Time, 
+	count int) (locked, blocked bool) {
+	for i := 0; i < count; i++ { locked, blocked = l.fail(host, account, now) }
+	return locked, blocked
+}
+
+func TestLockoutAccount(t *testing.T) {
+	l := NewLockout(LockoutConfig { AccountAttempts: 3, Window: 60, 
+		Duration: 900 })
+	now := time.Now()
+	if locked, _ := fails(l, "1.2.3.4", "Test", now, 2); locked { 
+		t.Fatal("locked before the limit") 
+	}
+	
+	// Usernames are counted without case, and from any address.
+	if locked, _ := l.fail("5.6.7.8", "test", now); !locked { 
+		t.Fatal("not locked at the limit") 
+	}
+	if locked, _ := l.fail("1.2.3.4", "Test", now); locked { 
+		t.Fatal("count didn't start over after locking") 
+	}
+	if l.blocked("1.2.3.4", now) { t.Fatal("address blocked without a limit") }
+}
+
+func TestLockoutAddress(t *testing.T) {
+	l := NewLockout(LockoutConfig { AddressAttempts: 3, Window: 60, 
+		Duration: 900 })
+	now := time.Now()
+	l.fail("1.2.3.4", "a", now)
+	l.fail("1.2.3.4", "", now)
+	if l.blocked("1.2.3.4", now) { t.Fatal("blocked before the limit") }
+	locked, blocked := l.fail("1.2.3.4", "b", now)
+	if locked || !blocked { t.Fatalf("locked %v, blocked %v", locked, blocked) }
+	if !l.blocked("1.2.3.4", now.Add(899 * time.Second)) { 
+		t.Fatal("block expired early") 
+	}
+	if l.blocked("5.6.7.8", now) { t.Fatal("blocked another address") }
+	if l.blocked("1.2.3.4", now.Add(901 * time.Second)) { 
+		t.Fatal("block didn't expire") 
+	}
+}
+
+// TestLockoutWindow checks that failures outside of the window aren't counted,
+// and that a zero window counts failures over the lockout's duration.
+func TestLockoutWindow(t *testing.T) {
+	l := NewLockout(LockoutConfig { AccountAttempts: 3, Window: 60, 
+		Duration: 900 })
+	now := time.Now()
+	fails(l, "1.2.3.4", "Test", now, 2)
+	if locked, _ := l.fail("1.2.3.4", "Test", now.Add(61 * time.Second)); locked {
+		t.Fatal("counted failures from the previous window")
+	}
+	if locked, _ := fails(l, "1.2.3.4", "Test", now.Add(90 * time.Second), 
+		2); !locked {
+		t.Fatal("not locked within the new window")
+	}
+	
+	l.Configure(LockoutConfig { AccountAttempts: 3, Duration: 900 })
+	fails(l, "1.2.3.4", "Test", now, 2)
+	if locked, _ := l.fail("1.2.3.4", "Test", now.Add(600 * time.Second)); !locked {
+		t.Fatal("zero window didn't count over the duration")
+	}
+}
+
+// TestLockoutDisabled checks that a zero duration disables the lockout, and zero
+// attempts disable their check.
+func TestLockoutDisabled(t *testing.T) {
+	l := NewLockout(LockoutConfig { AccountAttempts: 1, AddressAttempts: 1 })
+	now := time.Now()
+	if locked, blocked := fails(l, "1.2.3.4", "Test", now, 5); locked || blocked {
+		t.Fatalf("zero duration: locked %v, blocked %v", locked, blocked)
+	}
+	
+	l.Configure(LockoutConfig { AddressAttempts: 5, Duration: 900 })
+	if locked, blocked := fails(l, "1.2.3.4", "Test", now, 4); locked || blocked {
+		t.Fatalf("zero attempts: locked %v, blocked %v", locked, blocked)
+	}
+}
+
+func TestLockoutClear(t *testing.T) {
+	l := NewLockout(LockoutConfig { AccountAttempts: 3, AddressAttempts: 4, 
+		Window: 60, Duration: 900 })
+	now := time.Now()
+	
+	// Unlock forgets the account's failures, but not the address's.
+	fails(l, "1.2.3.4", "Test", now, 2)
+	l.Unlock("TEST")
+	if locked, _ := l.fail("1.2.3.4", "Test", now); locked { 
+		t.Fatal("account's failures weren't forgotten") 
+	}
+	if _, blocked := l.fail("1.2.3.4", "Other", now); !blocked {
+		t.Fatal("address's failures were forgotten")
+	}
+	
+	if !l.Unblock("1.2.3.4") { t.Fatal("address wasn't unblocked") }
+	if l.blocked("1.2.3.4", now) { t.Fatal("address is still blocked") }
+	if l.Unblock("1.2.3.4") { t.Fatal("address was unblocked twice") }
+	if l.Unblock("5.6.7.8") { t.Fatal("unknown address was unblocked") }
+}
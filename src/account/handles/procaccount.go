@@ -2,69 +2,87 @@ package handles
 
 import (
 	"account/db"
-	"crypto/sha1"
-	"encoding/gob"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
+	"lib/backend"
 	"lib/packets"
 	"lib/structures"
 	"lib/security"
+	"net"
 	"time"
-	"strings"
 )
 
 // AuthenticateLogin checks the user's account and password combination after
 // decrypting the password sent across the MsgAccount packet. The user's account 
 // loaded from the flat-file database, then sent to the game server for granted
-// access.
+// access. The client only gets one attempt per connection. Failed logins are 
+// counted by the login lockout; accounts and addresses with too many failures
+// are told to login later until their lock expires. Accounts with any status 
+// other than ACCTSTATUS_OK are rejected before the password is checked, once 
+// expired statuses have been lifted.
 func AuthenticateLogin(client *structures.Client, p *packets.MsgAccount) {
+	defer client.SetState(structures.STATE_DISCONNECTING)
+	if db.Kernel.Lockout.Blocked(client.RemoteAddr()) {
+		reject(client, 11, packets.MSGCONNECTEX_LOGIN_LATER)
+		return
+	}
+	
 	client.Account = new(structures.Account)
 	if db.LoadAccount(client.Account, p.Account) {
+		if client.Account.Expired() { expire(client.Account) }
 	
-		// Check if the user is banned.
-		if client.Account.Status == structures.ACCTSTATUS_BANNED {
-			response := packets.NewMsgConnectEx()
-			response.Token = 12
-			copy(response.Address[:], packets.MSGCONNECTEX_BANNED_ACCOUNT)
-			client.Send(response)
+		// Check if the user is banned or locked out.
+		if client.Account.Status != structures.ACCTSTATUS_OK {
+			token, message := rejection(client.Account.Status)
+			reject(client, token, message)
 		} else {
 			// Create a new account for the client.
 			client.Identity = client.Account.Identity
+			
+			// Clients which were sent a seed encrypt with the seed's key.
 			cipher := security.RC5 { }
-			cipher.Init()
+			if client.Seed != 0 { cipher.Seed(client.Seed) } else { cipher.Init() }
 			
-			// Decrypt the password from the client and hash it. Decrypting the 
-			// password here since the ciphertext is so weak. A plain SHA1 is already 
-			// effective without a thin middle layer of ciphertext.
-			hash := sha1.New()
+			// Decrypt the password from the client and verify it against the 
+			// account's salted hash. Decrypting the password here since the 
+			// ciphertext is so weak. Legacy SHA1 hashes are upgraded once the 
+			// password is known to be correct.
 			cipher.Decrypt(p.Password[:])
-			hash.Write(p.Password[:])
-			password := fmt.Sprintf("%x", hash.Sum(nil))
+			valid, upgrade := security.VerifyPassword(p.Password[:], 
+				client.Account.Password)
+			if upgrade { rehash(client.Account, p.Password[:]) }
 	
 			// Verify that the password is correct.
-			if strings.Compare(password, client.Account.Password) == 0 {
-				gameserver, exists := db.Kernel.GameServers[p.Server]
-				if exists && gameserver.Connection != nil { 
+			if valid {
+				db.Kernel.Lockout.Unlock(client.Account.Username)
+				gameserver := db.GetGameServer(p.Server)
+				if token, message := unavailable(gameserver); token != 0 {
+					reject(client, token, message)
+				} else {
 					
-					// Send authentication details to the game server.
+					// Send authentication details to the game server, with a new 
+					// one-time token for the client to connect with.
 					transfer := structures.Transfer {}
 					transfer.Account = *client.Account
-					transfer.IPAddress = client.Connection.RemoteAddr().String()
+					transfer.IPAddress = client.RemoteAddr().String()
 					transfer.Requested = time.Now()
-					encoder := gob.NewEncoder(gameserver.Connection)
-					err := encoder.Encode(transfer)
+					token, err := newtoken()
+					if err == nil {
+						transfer.Token = token
+						err = send(gameserver, transfer)
+					}
 					if err != nil {
 						
 						// Failed to send. Notify the player of server downtime.
-						response := packets.NewMsgConnectEx()
-						response.Token = 10
-						copy(response.Address[:], packets.MSGCONNECTEX_SERVER_DOWN[:])
-						client.Send(response)
+						reject(client, 10, packets.MSGCONNECTEX_SERVER_DOWN)
 						
 					} else { // Correct response. 
 						// Forward the client to the game server.
 						response := packets.NewMsgConnectEx()
 						response.Identity = client.Account.Identity
-						response.Token = client.Account.Authority
+						response.Token = transfer.Token
 						copy(response.Address[:], gameserver.Host)
 						response.Port = gameserver.Port
 						client.Send(response)
@@ -73,23 +91,121 @@ func AuthenticateLogin(client *structures.Client, p *packets.MsgAccount) {
 						fmt.Printf("Authenticated %s for %s\n", 
 							client.Account.Username, gameserver.Name)
 					}
-				} else { // The server doesn't exist or is offline.
-					response := packets.NewMsgConnectEx()
-					response.Token = 10
-					copy(response.Address[:], packets.MSGCONNECTEX_SERVER_DOWN)
-					client.Send(response)
 				}
 			} else { // Invalid username or password.
-				response := packets.NewMsgConnectEx()
-				response.Token = 1
-				copy(response.Address[:], packets.MSGCONNECTEX_INVALID_ACCOUNT)
-				client.Send(response)
+				if fail(client, client.Account) {
+					reject(client, 11, packets.MSGCONNECTEX_LOGIN_LATER)
+				} else { reject(client, 1, packets.MSGCONNECTEX_INVALID_ACCOUNT) }
 			}
 		}
 	} else { // Invalid username or password.
-		response := packets.NewMsgConnectEx()
-		response.Token = 1
-		copy(response.Address[:], packets.MSGCONNECTEX_INVALID_ACCOUNT)
-		client.Send(response)
+		fail(client, nil)
+		reject(client, 1, packets.MSGCONNECTEX_INVALID_ACCOUNT)
+	}
+}
+
+// reject answers the client's login with an error token and message.
+func reject(client *structures.Client, token uint32, message []byte) {
+	response := packets.NewMsgConnectEx()
+	response.Token = token
+	copy(response.Address[:], message)
+	client.Send(response)
+}
+
+// fail counts a failed login against the client's address and the account, if 
+// the account exists. If the account has failed too many times, it's locked 
+// until the lockout's duration has passed, and the lock is saved to the account
+// file. Returns true if the account was locked.
+func fail(client *structures.Client, account *structures.Account) bool {
+	username := ""
+	if account != nil { username = account.Username }
+	locked, blocked := db.Kernel.Lockout.Fail(client.RemoteAddr(), username)
+	duration := db.Kernel.Lockout.Duration()
+	if blocked { 
+		host, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+		fmt.Printf("Blocked logins from %s for %s\n", host, duration) 
+	}
+	if !locked { return false }
+	_, err := db.UpdateAccount(account.Username, func(a *structures.Account) bool {
+		a.SetStatus(structures.ACCTSTATUS_LOCKED, time.Now().Add(duration), 
+			"too many failed logins", 0)
+		return true
+	})
+	if err != nil { fmt.Println(err); return true }
+	fmt.Printf("Locked %s for %s after failed logins\n", account.Username, duration)
+	return true
+}
+
+// expire lifts the account's expired status and saves it. The account is 
+// replaced with the saved account, in case its status was changed again since
+// it was loaded. If the account can't be saved, its status is only lifted for 
+// this login.
+func expire(account *structures.Account) {
+	updated, err := db.UpdateAccount(account.Username, 
+		(*structures.Account).Expire)
+	if err != nil { fmt.Println(err); account.Expire(); return }
+	*account = *updated
+}
+
+// rejection returns the MsgConnectEx token and message for an account status. 
+// Locked accounts are told to login later; banned accounts, and accounts with an
+// unknown status, are told they're banned.
+func rejection(status uint32) (uint32, []byte) {
+	switch status {
+	case structures.ACCTSTATUS_LOCKED: return 11, packets.MSGCONNECTEX_LOGIN_LATER
+	default: return 12, packets.MSGCONNECTEX_BANNED_ACCOUNT
+	}
+}
+
+// unavailable returns the MsgConnectEx token and message for a game server which 
+// can't accept the player, or a zero token if the player can be transferred. 
+// Players are told the server is down if it doesn't exist, is offline, isn't
+// accepting logins, or has stopped reporting its status, and to login later if 
+// it's full. Game servers which haven't reported their status yet are assumed to
+// be accepting logins.
+func unavailable(gameserver *structures.GameServer) (uint32, []byte) {
+	if gameserver == nil || gameserver.Connection() == nil { 
+		return 10, packets.MSGCONNECTEX_SERVER_DOWN 
+	}
+	status := gameserver.Status()
+	if status == nil { return 0, nil }
+	if !status.Accepting || status.Stale() { 
+		return 10, packets.MSGCONNECTEX_SERVER_DOWN 
+	}
+	if status.Full() { return 11, packets.MSGCONNECTEX_LOGIN_LATER }
+	return 0, nil
+}
+
+// send sends a transfer to the game server. The game server may go offline 
+// after it's checked by unavailable, in which case an error is returned.
+func send(gameserver *structures.GameServer, transfer structures.Transfer) error {
+	connection := gameserver.Connection()
+	if connection == nil { return net.ErrClosed }
+	return connection.Send(backend.MESSAGE_TRANSFER, transfer)
+}
+
+// rehash replaces an account's password hash with a new hash of the password the
+// account just logged in with. If the account can't be saved, the old hash is 
+// kept, and the account will be rehashed on its next login instead. The hash 
+// isn't replaced if the password was changed since the account was loaded.
+func rehash(account *structures.Account, password []byte) {
+	hash, err := security.HashPassword(string(bytes.TrimRight(password, "\x00")))
+	if err != nil { fmt.Println(err); return }
+	updated, err := db.UpdateAccount(account.Username, 
+		func(a *structures.Account) bool {
+			if a.Password != account.Password { return false }
+			a.Password = hash
+			return true
+		})
+	if err != nil { fmt.Println(err); return }
+	account.Password = updated.Password
+}
+
+// newtoken returns a random, non-zero token for a transfer.
+func newtoken() (uint32, error) {
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil { return 0, err }
+		if token := binary.LittleEndian.Uint32(b[:]); token != 0 { return token, nil }
 	}
-}
\ No newline at end of file
+}
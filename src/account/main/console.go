@@ -0,0 +1,57 @@
+package main
+
+import (
+	"account/db"
+	"bufio"
+	"fmt"
+	"lib/structures"
+	"os"
+	"strings"
+	"time"
+)
+
+// console reads commands from the operator on standard input until the input is
+// closed. Commands are used to manage the server while it's running, such as
+// clearing locks from the login lockout. The reload command calls reload.
+func console(reload func()) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 { continue }
+		switch command := strings.ToLower(fields[0]); {
+		case command == "unlock" && len(fields) == 2: unlock(fields[1])
+		case command == "unblock" && len(fields) == 2: unblock(fields[1])
+		case command == "reload" && len(fields) == 1: reload()
+		case command == "help":
+			fmt.Println("unlock <username>   clears an account's login lockout")
+			fmt.Println("unblock <address>   clears an IP address's login lockout")
+			fmt.Println("reload              reloads the configuration and game servers")
+		default: fmt.Printf("unknown command %q, see help\n", scanner.Text())
+		}
+	}
+}
+
+// unlock clears an account's lock and forgets its failed logins. The account's
+// lock is cleared whether it was locked by the login lockout or by hand.
+func unlock(username string) {
+	locked := false
+	account, err := db.UpdateAccount(username, func(a *structures.Account) bool {
+		if a.Status != structures.ACCTSTATUS_LOCKED { return false }
+		a.SetStatus(structures.ACCTSTATUS_OK, time.Time {}, 
+			"unlocked from the console", 0)
+		locked = true
+		return true
+	})
+	if err != nil { fmt.Println(err); return }
+	db.Kernel.Lockout.Unlock(account.Username)
+	if locked { 
+		fmt.Printf("Unlocked %s\n", account.Username) 
+	} else { fmt.Printf("account %s isn't locked\n", account.Username) }
+}
+
+// unblock clears an IP address's block and failed logins.
+func unblock(address string) {
+	if db.Kernel.Lockout.Unblock(address) {
+		fmt.Printf("Unblocked %s\n", address)
+	} else { fmt.Printf("address %s isn't blocked\n", address) }
+}
@@ -2,43 +2,55 @@ package main
 
 import (
 	"account/handles"
-	"bytes"
+	"crypto/rand"
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
+	"lib/network"
+	"lib/protocol"
 	"lib/structures"
 	"lib/packets"
 	"lib/security"
+	"time"
 )
 
-// OnConnect is called by the auth server to initialize the client structure upon
-// connection. Client ciphers should be initialized here. If the server has been 
-// upgraded to the new RC5 seed procedure from around patch 5180, then send the 
-// MsgEncryptCode packet here after cipher initialization. 
-func OnConnect(client *structures.Client) {
-	client.Cipher = new(security.TQCipher)
-	client.Cipher.Init()
+// OnConnect returns the event called by the auth server to initialize the client
+// structure upon connection, for a listener's protocol profile. Client ciphers 
+// are initialized here. If the profile uses the RC5 seed procedure from around 
+// patch 5180, a random seed is generated for the client and sent in the 
+// MsgEncryptCode packet after cipher initialization. 
+func OnConnect(profile *protocol.Profile) func(*structures.Client) {
+	return func(client *structures.Client) {
+		client.Cipher = new(security.TQCipher)
+		client.Cipher.Init()
+		if profile.Seeded {
+			var seed [4]byte
+			for client.Seed == 0 {
+				if _, err := rand.Read(seed[:]); err != nil { 
+					fmt.Println(err)
+					client.Disconnect()
+					return 
+				}
+				client.Seed = binary.LittleEndian.Uint32(seed[:])
+			}
+			client.Send(packets.NewMsgEncryptCode(client.Seed))
+		}
+	}
 }
 
-// OnReceive is called by the auth server to handle packets from the player's
-// game client. At this point, the server has assembled fragments and split the 
-// packet buffer into multiple packets. This function accepts a decrypted packet 
-// to be processed in the passed buffer.
-func OnReceive(client *structures.Client, buffer *bytes.Buffer) {
-	b := buffer.Bytes()
-	length := binary.LittleEndian.Uint16(b[0:2])
-	identity := binary.LittleEndian.Uint16(b[2:4])
-	switch identity {
-		
+// NewDispatcher creates the packet dispatcher used as the auth server's OnReceive
+// event. At this point, the server has assembled fragments and split the packet 
+// buffer into multiple packets. The dispatcher decodes each decrypted packet and
+// calls the handler registered for its identifier below, if the packet is legal
+// in the client's state.
+func NewDispatcher(logging bool) *network.Dispatcher {
+	d := network.NewDispatcher()
+	d.Use(network.Timing(100 * time.Millisecond))
+	if logging { d.Use(network.Logging) }
+	
 	// 1051: MsgAccount
-	case packets.MSGACCOUNT:
-		packet := new(packets.MsgAccount)
-		err := packets.Read(buffer, packet)
-		if err != nil { fmt.Println(err) } else { 
-			handles.AuthenticateLogin(client, packet) 
-		}
-	default:
-		fmt.Println("Missing packet handle:", identity, "length", length)
-		fmt.Println(hex.Dump(b))
-	}
-}
\ No newline at end of file
+	d.Handle(packets.MSGACCOUNT, new(packets.MsgAccount), 
+		func(c *structures.Client, p interface{}, b []byte) {
+			handles.AuthenticateLogin(c, p.(*packets.MsgAccount))
+		}, structures.STATE_CONNECTED)
+	return d
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"account/db"
+	"context"
 	"fmt"
 	"lib/network"
+	"lib/protocol"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -13,11 +18,11 @@ func main() {
 	// copyright. All derivations must include this copyright and license.
 	fmt.Println(`GoConquer, Account Server`)
 	fmt.Println("Copyright(C) 2016 Gareth Warry, Matt Moening")
-	fmt.Println("Version 1.0, May 2016\n")
+	fmt.Print("Version 1.0, May 2016\n\n")
 	fmt.Println("This work is licensed under the Creative Commons Attribution-");
 	fmt.Println("NonCommercial-ShareAlike 4.0 International (CC-BY-NC) License.");
 	fmt.Println("A copy of this license is available to you in the distribution");
-	fmt.Println("of this software.\n");
+	fmt.Print("of this software.\n\n");
 	
 	// Read in the user's configuration file for the server.
 	fmt.Println("Initializing server...")
@@ -26,19 +31,98 @@ func main() {
 	
 	// Load flat-file database.
 	if !db.LoadGameServers() { fmt.Printf("failed\n"); os.Exit(-1) }
+	db.Kernel.Lockout = db.NewLockout(db.Configuration.Lockout)
 	
-	// Create the server instance and start listening.
-	ch := make(chan bool)
-	server := network.Server{} 
-	server.OnConnect = OnConnect
-	server.OnReceive = OnReceive
-	go server.Listen(db.Configuration.Host, ch) 
-	fmt.Println("Listening for new connections\n")
+	// Create a server instance for each listener and start listening. The 
+	// admission layer, flood policy, and dispatcher are shared by all listeners.
+	// Profiles only select the password seed on the account server; the account
+	// server's cipher is the same for every patch.
+	admission, err := network.NewAdmission(db.Configuration.Admission)
+	if err != nil { fmt.Println(err.Error()); os.Exit(-1) }
+	flood := network.NewFloodPolicy(db.Configuration.Flood)
+	proxies, err := network.ParseNetworks(db.Configuration.TrustedProxies)
+	if err != nil { fmt.Println(err.Error()); os.Exit(-1) }
+	dispatcher := NewDispatcher(db.Configuration.LogPackets)
+	listeners := db.Configuration.Listeners
+	if len(listeners) == 0 { 
+		listeners = []db.Listener {{ Host: db.Configuration.Host }} 
+	}
+	
+	ch := make(chan bool, len(listeners))
+	servers := make([]*network.Server, 0, len(listeners))
+	for _, listener := range listeners {
+		if listener.Profile == "" { listener.Profile = protocol.DEFAULT_PROFILE }
+		profile := protocol.Lookup(listener.Profile)
+		if profile == nil { 
+			fmt.Printf("unknown protocol profile %s\n", listener.Profile)
+			os.Exit(-1) 
+		}
+		server := new(network.Server)
+		server.Admission = admission
+		server.Flood = flood
+		server.Capture = db.Configuration.Capture
+		server.CrashReports = db.Configuration.CrashReports
+		server.Proxies = proxies
+		server.OnConnect = OnConnect(profile)
+		server.OnReceive = dispatcher.Dispatch
+		server.Timeout = time.Duration(db.Configuration.Timeout) * time.Second
+		servers = append(servers, server)
+		go server.Listen(listener.Host, ch) 
+		fmt.Printf("Listening for %s clients on %s\n", profile.Name, listener.Host)
+	}
+	go console(func() { reload(servers[0]) })
+	fmt.Println()
 	
-	// Terminate the program only when done listening for connections.
-	serverstate := <-ch
-	if !serverstate {
-		fmt.Println("server terminated unexpectedly")
-		os.Exit(-1)
+	// Terminate the program when done listening for connections, or once an
+	// interrupt or termination signal has been received from the operator. A 
+	// hangup signal (or the console's reload command) reloads the configuration
+	// file and game servers.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case <-ch:
+			fmt.Println("server terminated unexpectedly")
+			os.Exit(-1)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP { reload(servers[0]) } else { running = false }
+		}
 	}
-}
\ No newline at end of file
+	
+	// Disconnect all clients before exiting.
+	fmt.Println("Shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil { fmt.Println(err) }
+	}
+	panics := uint64(0)
+	for _, server := range servers { 
+		<-ch
+		panics += server.Panics()
+	}
+	for identifier, count := range dispatcher.Unknown() {
+		fmt.Printf("unhandled packet %d received %d times\n", identifier, count)
+	}
+	if panics > 0 { fmt.Printf("recovered from %d panics\n", panics) }
+	fmt.Println("Server shut down")
+}
+
+// reload is called when the server receives a hangup signal. It decodes the 
+// configuration file again and applies the settings which can be changed while
+// the server is running, such as the admission lists, flood limits, and login 
+// lockout. The game servers are reloaded as well. Listeners share the admission
+// layer and flood policy, so any server can be passed.
+func reload(server *network.Server) {
+	fmt.Println("Reloading configuration...")
+	configuration := db.Configuration
+	configuration.Admission = network.AdmissionConfig {}
+	configuration.Flood = network.FloodConfig {}
+	configuration.Lockout = db.LockoutConfig {}
+	err := configuration.Decode("./configuration.json")
+	if err == nil { err = server.Admission.Configure(configuration.Admission) }
+	if err != nil { fmt.Println(err.Error()); return }
+	server.Flood.Configure(configuration.Flood)
+	db.Kernel.Lockout.Configure(configuration.Lockout)
+	if !db.LoadGameServers() { fmt.Println("failed to reload game servers") }
+}
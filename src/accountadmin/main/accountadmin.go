@@ -0,0 +1,237 @@
+// Accountadmin manages the account server's flat-file account database, so
+// accounts don't need to be edited by hand. Usage:
+//
+//	conquer-account [-dir bin/account] [-moderator 0] [-reason text] command 
+//		[arguments]
+//
+// Commands are:
+//
+//	create <username> [authority]   creates an account
+//	reset <username>                resets an account's password
+//	authority <username> <level>    changes an account's authority
+//	ban <username> [duration]       bans an account, indefinitely by default
+//	lock <username> [duration]      locks an account, indefinitely by default
+//	unlock <username>               clears an account's lock or ban
+//	history <username>              lists an account's moderation history
+//	list                            lists all accounts
+//	search <text>                   lists accounts by username or identity
+//
+// Passwords are read from standard input, so they don't show up in the shell's
+// history. Bans, locks, and unlocks are recorded in the account's moderation 
+// history with the reason and the moderator's account identity, which must be
+// given for those commands. Changes take effect on the account's next login, 
+// and the account server doesn't need to be stopped; accounts are locked while
+// they're changed, so the tool and the server never overwrite each other's 
+// changes. However, unlocking an account here doesn't clear the running 
+// server's count of failed logins (use the server's unlock command for that).
+package main
+
+import (
+	"account/db"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"lib/structures"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrModerator = errors.New("the moderator's account identity is required " +
+	"(-moderator)")
+
+// moderator and reason are recorded in the account's history when its status is 
+// changed.
+var moderator uint
+var reason string
+
+func main() {
+	dir := flag.String("dir", ".", "the account server's directory")
+	flag.UintVar(&moderator, "moderator", 0, 
+		"account identity of the moderator changing an account's status")
+	flag.StringVar(&reason, "reason", "", "reason for changing an account's status")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 { usage(); os.Exit(2) }
+	if err := os.Chdir(*dir); err != nil { fmt.Println(err); os.Exit(1) }
+	
+	// Run the command with its arguments.
+	var err error
+	args := flag.Args()[1:]
+	switch command := flag.Arg(0); {
+	case command == "create" && (len(args) == 1 || len(args) == 2):
+		err = create(args)
+	case command == "reset" && len(args) == 1:
+		err = reset(args[0])
+	case command == "authority" && len(args) == 2:
+		err = authority(args[0], args[1])
+	case command == "ban" && (len(args) == 1 || len(args) == 2):
+		err = status(args, structures.ACCTSTATUS_BANNED)
+	case command == "lock" && (len(args) == 1 || len(args) == 2):
+		err = status(args, structures.ACCTSTATUS_LOCKED)
+	case command == "unlock" && len(args) == 1:
+		err = status(args, structures.ACCTSTATUS_OK)
+	case command == "history" && len(args) == 1:
+		err = history(args[0])
+	case command == "list" && len(args) == 0:
+		err = search("")
+	case command == "search" && len(args) == 1:
+		err = search(args[0])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil { fmt.Println(err); os.Exit(1) }
+}
+
+// usage prints the tool's usage, flags, and commands.
+func usage() {
+	fmt.Println("usage: conquer-account [flags] command [arguments]")
+	flag.PrintDefaults()
+	fmt.Println("commands:")
+	fmt.Println("  create <username> [authority]")
+	fmt.Println("  reset <username>")
+	fmt.Println("  authority <username> <level>")
+	fmt.Println("  ban <username> [duration]")
+	fmt.Println("  lock <username> [duration]")
+	fmt.Println("  unlock <username>")
+	fmt.Println("  history <username>")
+	fmt.Println("  list")
+	fmt.Println("  search <text>")
+}
+
+// create creates an account with the password read from standard input.
+func create(args []string) error {
+	account := &structures.Account { Username: args[0] }
+	if len(args) == 2 {
+		level, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil { return err }
+		account.Authority = uint32(level)
+	}
+	if !db.ValidUsername(account.Username) { return db.ErrInvalidUsername }
+	password, err := readpassword()
+	if err != nil { return err }
+	if err := db.CreateAccount(account, password); err != nil { return err }
+	fmt.Printf("Created %s with identity %d\n", account.Username, account.Identity)
+	return nil
+}
+
+// reset replaces an account's password with a password read from standard input.
+func reset(username string) error {
+	if _, err := load(username); err != nil { return err }
+	password, err := readpassword()
+	if err != nil { return err }
+	hashed := new(structures.Account)
+	if err := db.SetPassword(hashed, password); err != nil { return err }
+	return update(username, "Reset the password for %s\n", 
+		func(account *structures.Account) bool {
+			account.Password = hashed.Password
+			return true
+		})
+}
+
+// authority changes an account's authority level.
+func authority(username, level string) error {
+	value, err := strconv.ParseUint(level, 10, 32)
+	if err != nil { return err }
+	return update(username, "Changed the authority of %s\n", 
+		func(account *structures.Account) bool {
+			account.Authority = uint32(value)
+			return true
+		})
+}
+
+// status changes an account's status, with the account's username and an 
+// optional duration as arguments. A duration makes the status temporary; the 
+// account returns to ACCTSTATUS_OK once it expires.
+func status(args []string, status uint32) error {
+	if moderator == 0 { return ErrModerator }
+	expires := time.Time {}
+	if len(args) == 2 {
+		duration, err := time.ParseDuration(args[1])
+		if err != nil { return err }
+		if duration <= 0 { return fmt.Errorf("invalid duration %s", args[1]) }
+		expires = time.Now().Add(duration)
+	}
+	account, err := db.UpdateAccount(args[0], func(a *structures.Account) bool {
+		a.SetStatus(status, expires, reason, uint32(moderator))
+		return true
+	})
+	if err != nil { return err }
+	fmt.Printf("Changed the status of %s to %s\n", account.Username, 
+		describe(account.Status, account.StatusExpires))
+	return nil
+}
+
+// history prints an account's moderation history, oldest first.
+func history(username string) error {
+	account, err := load(username)
+	if err != nil { return err }
+	for _, entry := range account.History {
+		fmt.Printf("%s  %-32s by %-10d %s\n", entry.Time.Format(time.DateTime), 
+			describe(entry.Status, entry.Expires), entry.Moderator, entry.Reason)
+	}
+	return nil
+}
+
+// search lists the accounts whose username contains the text (ignoring case), or
+// whose identity is the text. An empty text lists all accounts.
+func search(text string) error {
+	accounts, err := db.ListAccounts()
+	if err != nil { return err }
+	text = strings.ToLower(text)
+	for _, account := range accounts {
+		if !strings.Contains(strings.ToLower(account.Username), text) &&
+			strconv.FormatUint(uint64(account.Identity), 10) != text { continue }
+		fmt.Printf("%-10d %-16s authority %-3d %s\n", account.Identity,
+			account.Username, account.Authority, 
+			describe(account.Status, account.StatusExpires))
+	}
+	return nil
+}
+
+// load loads an account by username, with an error if the account doesn't exist.
+func load(username string) (*structures.Account, error) {
+	if !db.ValidUsername(username) { return nil, db.ErrInvalidUsername }
+	account := new(structures.Account)
+	if !db.LoadAccount(account, username) {
+		return nil, fmt.Errorf("account %s doesn't exist", username)
+	}
+	return account, nil
+}
+
+// update changes an account with db.UpdateAccount, and prints the message 
+// (formatted with the username) once the account has been saved.
+func update(username, message string, 
+	change func(account *structures.Account) bool) error {
+	account, err := db.UpdateAccount(username, change)
+	if err != nil { return err }
+	fmt.Printf(message, account.Username)
+	return nil
+}
+
+// describe returns an account status as text, with its expiry if temporary.
+func describe(status uint32, expires time.Time) string {
+	var name string
+	switch status {
+	case structures.ACCTSTATUS_OK: name = "ok"
+	case structures.ACCTSTATUS_LOCKED: name = "locked"
+	case structures.ACCTSTATUS_BANNED: name = "banned"
+	case structures.ACCTSTATUS_LIKESUPERHELLABANNED: name = "banned"
+	default: name = fmt.Sprintf("status %d", status)
+	}
+	if !expires.IsZero() {
+		name += " until " + expires.Format(time.DateTime)
+	}
+	return name
+}
+
+// readpassword reads a password from standard input, prompting for it first.
+func readpassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" { return "", err }
+	return strings.TrimRight(line, "\r\n"), nil
+}
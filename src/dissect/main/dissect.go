@@ -0,0 +1,342 @@
+// Dissect decodes the packets of a raw TCP stream, a hex dump, or a session 
+// capture, and pretty-prints each packet by identifier with the field names from
+// lib/packets. Usage:
+//
+//	conquer-dissect [-format auto] [-from client] [-mode game] [-cipher auto] 
+//		[-token 0] [-identity 0] [-patch 0] [-seed 0] stream.bin
+//
+// Raw streams are the bytes sent by one side of a connection, as saved from a 
+// packet sniffer, and are decrypted with the TQCipher. Packets sent by the client
+// are decrypted with the server's side of the cipher: in game mode, the cipher is 
+// re-keyed after MsgConnect using the token and identity from the packet (or from 
+// the -token and -identity flags, if the packet's are wrong or missing). Hex 
+// dumps, such as the hex.Dump output logged by the servers, and capture files are
+// already decrypted. The password in MsgAccount is also decrypted with RC5, 
+// using the key from the seed in MsgEncryptCode (or the -seed flag) if one was 
+// sent. 
+// Packet layouts are decoded for the -patch flag's client patch; by default, the 
+// patch is taken from the version in MsgConnect once it's been seen. Packets 
+// with unknown identifiers are printed with their length and a hex dump. 
+// A file name of "-" reads from standard input.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"lib/capture"
+	"lib/packets"
+	"lib/security"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// patch is the client patch used to decode packet layouts. It's updated from the 
+// version in MsgConnect unless it was set by flag.
+var patch uint16
+
+// seed is the password seed from MsgEncryptCode or the -seed flag, or zero if 
+// the password is encrypted with RC5's default key.
+var seed uint32
+
+// stream decrypts and dissects the packets sent by one side of a connection.
+type stream struct {
+	decrypt  func([]byte)
+	generate func(token, identity uint32)
+	token    uint32
+	identity uint32
+	count    int
+}
+
+func main() {
+	format := flag.String("format", "auto", "input format: raw, hex, capture, or auto")
+	from := flag.String("from", "client", "sender of a stream: client or server")
+	mode := flag.String("mode", "game", "server type: account or game")
+	cipher := flag.String("cipher", "auto", 
+		"stream cipher: tq, none, or auto (tq for raw streams)")
+	token := flag.Uint("token", 0, "MsgConnect token for re-keying the game cipher")
+	identity := flag.Uint("identity", 0, 
+		"MsgConnect identity for re-keying the game cipher")
+	patchflag := flag.Uint("patch", 0, "client patch for decoding packet layouts")
+	seedflag := flag.Uint("seed", 0, "MsgEncryptCode seed for the password cipher")
+	flag.Parse()
+	patch = uint16(*patchflag)
+	seed = uint32(*seedflag)
+	if flag.NArg() != 1 || (*from != "client" && *from != "server") ||
+		(*mode != "account" && *mode != "game") {
+		fmt.Println("usage: conquer-dissect [flags] file")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	
+	// Read the input file and detect its format.
+	var data []byte
+	var err error
+	if flag.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else { data, err = os.ReadFile(flag.Arg(0)) }
+	if err != nil { fmt.Println(err); os.Exit(1) }
+	if *format == "auto" {
+		if bytes.HasPrefix(data, []byte(capture.CAPTURE_MAGIC)) {
+			*format = "capture"
+		} else if _, err := parsehex(data); err == nil && istext(data) {
+			*format = "hex"
+		} else { *format = "raw" }
+	}
+	
+	// Dissect the input according to its format.
+	switch *format {
+	case "capture":
+		err = dissectcapture(data)
+	case "hex", "raw":
+		if *format == "hex" {
+			if data, err = parsehex(data); err != nil { break }
+		}
+		if *cipher == "auto" {
+			*cipher = "none"
+			if *format == "raw" { *cipher = "tq" }
+		}
+		s := newstream(*from, *mode, *cipher)
+		s.token, s.identity = uint32(*token), uint32(*identity)
+		s.dissect(data, *from)
+	default:
+		err = fmt.Errorf("unknown format %s", *format)
+	}
+	if err != nil { fmt.Println(err); os.Exit(1) }
+}
+
+// newstream creates a stream for the sender and cipher. Packets from the client 
+// are decrypted with the server's side of the TQCipher, and packets from the 
+// server with the client's side.
+func newstream(from, mode, cipher string) *stream {
+	s := &stream { decrypt: func([]byte) {} }
+	if cipher != "tq" { return s }
+	if from == "client" {
+		c := new(security.TQCipher)
+		c.Init()
+		s.decrypt = c.Decrypt
+		if mode == "game" { s.generate = c.Generate }
+	} else {
+		c := new(security.TQClientCipher)
+		c.Init()
+		s.decrypt = c.Decrypt
+	}
+	return s
+}
+
+// dissect decrypts each packet in the stream and prints it. The stream is 
+// decrypted in place.
+func (s *stream) dissect(data []byte, from string) {
+	for offset := 0; offset < len(data); {
+		if len(data) - offset < 4 {
+			fmt.Printf("Trailing %d bytes at offset %d\n", len(data) - offset, offset)
+			dump(data[offset:])
+			return
+		}
+		
+		// Decrypt the length first, then the remainder of the packet.
+		s.decrypt(data[offset:offset + 2])
+		length := int(binary.LittleEndian.Uint16(data[offset:]))
+		if length < 4 || offset + length > len(data) {
+			fmt.Printf("Invalid packet length %d at offset %d\n", length, offset)
+			s.decrypt(data[offset + 2:])
+			dump(data[offset:])
+			return
+		}
+		s.decrypt(data[offset + 2:offset + length])
+		s.count++
+		packet := show(s.count, from, -1, data[offset:offset + length])
+		
+		// Re-key the game server's cipher after MsgConnect, like the server.
+		if connect, ok := packet.(*packets.MsgConnect); ok && s.generate != nil {
+			token, identity := connect.Token, connect.Identity
+			if s.token != 0 || s.identity != 0 { token, identity = s.token, s.identity }
+			s.generate(token, identity)
+			s.generate = nil
+		}
+		offset += length
+	}
+}
+
+// dissectcapture prints each record from a session capture file.
+func dissectcapture(data []byte) error {
+	reader, err := capture.NewReader(bytes.NewReader(data))
+	if err != nil { return err }
+	fmt.Printf("Session from %s, captured %s\n\n", reader.Address, 
+		reader.Started.Format(time.RFC1123))
+	for count := 1; ; count++ {
+		record, err := reader.Next()
+		if err == io.EOF { return nil }
+		if err != nil { return err }
+		from := "client"
+		if record.Direction == capture.OUTBOUND { from = "server" }
+		show(count, from, record.Offset, record.Data)
+	}
+}
+
+// show prints a decrypted packet with its fields, and returns the decoded packet
+// structure (or nil if the packet couldn't be decoded). Offset is the time of the
+// packet in the session, or negative if unknown.
+func show(count int, from string, offset time.Duration, data []byte) interface{} {
+	direction := "client -> server"
+	if from == "server" { direction = "server -> client" }
+	if offset >= 0 { direction += fmt.Sprintf(" +%s", offset) }
+	if len(data) < 4 {
+		fmt.Printf("#%d %s, length %d\n", count, direction, len(data))
+		dump(data)
+		return nil
+	}
+	
+	identifier := binary.LittleEndian.Uint16(data[2:4])
+	packet := packets.Lookup(identifier)
+	if packet == nil {
+		fmt.Printf("#%d %s  %d (unknown), length %d\n", count, direction, 
+			identifier, len(data))
+		dump(data)
+		return nil
+	}
+	name := reflect.TypeOf(packet).Elem().Name()
+	fmt.Printf("#%d %s  %s (%d), length %d\n", count, direction, name, identifier, 
+		len(data))
+	if err := packets.ReadPatch(bytes.NewBuffer(data), packet, patch); err != nil {
+		fmt.Printf("  %s\n", err)
+		dump(data)
+		return nil
+	}
+	fields(reflect.ValueOf(packet).Elem(), "  ")
+	if connect, ok := packet.(*packets.MsgConnect); ok && patch == 0 {
+		version, err := strconv.ParseUint(strings.TrimSpace(connect.Version), 10, 16)
+		if err == nil { patch = uint16(version) }
+	}
+	
+	if code, ok := packet.(*packets.MsgEncryptCode); ok && seed == 0 { 
+		seed = code.Seed 
+	}
+	
+	// Decrypt the password in MsgAccount, like the account server.
+	if account, ok := packet.(*packets.MsgAccount); ok {
+		password := account.Password
+		cipher := security.RC5 { }
+		if seed != 0 { cipher.Seed(seed) } else { cipher.Init() }
+		cipher.Decrypt(password[:])
+		fmt.Printf("  Password (RC5): %q\n", 
+			strings.TrimRight(string(password[:]), "\x00"))
+	}
+	fmt.Println()
+	return packet
+}
+
+// fields prints the exported fields of a structure, recursing into nested 
+// structures and collections. The packet header is skipped, since it's printed
+// with the packet's name.
+func fields(v reflect.Value, indent string) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		f, value := t.Field(i), v.Field(i)
+		if f.PkgPath != "" || (f.Anonymous && f.Type.Name() == "PacketHeader") { 
+			continue 
+		}
+		switch value.Kind() {
+		case reflect.Struct:
+			fmt.Printf("%s%s:\n", indent, f.Name)
+			fields(value, indent + "  ")
+			
+		case reflect.Array, reflect.Slice:
+			if value.Type().Elem().Kind() == reflect.Uint8 {
+				fmt.Printf("%s%s: %s\n", indent, f.Name, binaryvalue(value))
+				continue
+			}
+			fmt.Printf("%s%s: [%d]\n", indent, f.Name, value.Len())
+			for j := 0; j < value.Len(); j++ {
+				if value.Index(j).Kind() == reflect.Struct {
+					fmt.Printf("%s  [%d]:\n", indent, j)
+					fields(value.Index(j), indent + "    ")
+				} else {
+					fmt.Printf("%s  [%d] %s\n", indent, j, format(value.Index(j)))
+				}
+			}
+			
+		default:
+			fmt.Printf("%s%s: %s\n", indent, f.Name, format(value))
+		}
+	}
+}
+
+// format returns a field value for printing. Strings are quoted, and integers 
+// are printed in decimal and hexadecimal.
+func format(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%d (0x%X)", v.Uint(), v.Uint())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%d", v.Int())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// binaryvalue returns a byte array or slice for printing in hex. If the bytes 
+// are printable text padded with zeros (such as MsgConnectEx's address), the 
+// text is also printed.
+func binaryvalue(v reflect.Value) string {
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	text := strings.TrimRight(string(b), "\x00")
+	printable := len(text) > 0
+	for _, c := range []byte(text) { 
+		if c < 0x20 || c > 0x7E { printable = false } 
+	}
+	if printable { return fmt.Sprintf("% x %q", b, text) }
+	return fmt.Sprintf("% x", b)
+}
+
+// dump prints a hex dump of data, indented under the packet.
+func dump(data []byte) {
+	for _, line := range strings.SplitAfter(hex.Dump(data), "\n") {
+		if line != "" { fmt.Print("  " + line) }
+	}
+	fmt.Println()
+}
+
+// istext returns true if data only contains printable text and whitespace.
+func istext(data []byte) bool {
+	for _, c := range data {
+		if (c < 0x20 || c > 0x7E) && c != '\n' && c != '\r' && c != '\t' { return false }
+	}
+	return true
+}
+
+// parsehex parses a hex dump into bytes. It accepts the output of hex.Dump (with 
+// offsets and a text column) and plain hex, with or without spaces or 0x 
+// prefixes. Lines which aren't hex, such as log messages, are skipped; but at 
+// least one line must be hex.
+func parsehex(text []byte) ([]byte, error) {
+	var data []byte
+	lines := 0
+	for _, line := range strings.Split(string(text), "\n") {
+		if i := strings.Index(line, "|"); i >= 0 { line = line[:i] }
+		words := strings.Fields(line)
+		if len(words) > 1 && len(words[0]) == 8 && strings.HasPrefix(line, words[0] + "  ") {
+			words = words[1:] // Offset column from hex.Dump.
+		}
+		
+		var decoded []byte
+		valid := len(words) > 0
+		for _, word := range words {
+			word = strings.TrimSuffix(strings.TrimPrefix(word, "0x"), ",")
+			b, err := hex.DecodeString(word)
+			if err != nil { valid = false; break }
+			decoded = append(decoded, b...)
+		}
+		if valid { data = append(data, decoded...); lines++ }
+	}
+	if lines == 0 { return nil, fmt.Errorf("no hex found") }
+	return data, nil
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"bufio"
 	"encoding/json"
+	"lib/network"
 	"os"
 )
 
@@ -11,9 +12,66 @@ import (
 // found in the same directory as the executable.
 var Configuration configuration
 type configuration struct {
-	Host     string
+	// Host is the address clients connect to, unless Listeners is set.
+	Host string
+	
+	// Listeners optionally replaces Host with several addresses, each serving 
+	// the client patches of a protocol profile (the default profile if not 
+	// specified).
+	Listeners []Listener
+	
+	// AuthHost is the account server's address, and AuthPort is the port it 
+	// connects to for the backend channel.
 	AuthHost string
 	AuthPort int
+	
+	// BackendSecret is the shared secret the account server must authenticate 
+	// with on the backend channel, in addition to connecting from AuthHost. It 
+	// must be at least 16 bytes; the server won't start without one.
+	BackendSecret string
+	
+	// TransferWindow is the number of seconds a client has to connect after the
+	// account server transfers its login; zero uses the default window.
+	TransferWindow int
+	
+	// Capacity is the most players allowed online at once (zero for no limit), 
+	// and Maintenance stops the account server from sending players to the game
+	// server. Both can be changed by reloading the configuration.
+	Capacity    int
+	Maintenance bool
+	
+	// StatusInterval is the number of seconds between status reports to the 
+	// account server; zero uses the default interval.
+	StatusInterval int
+	
+	// Timeout and Heartbeat are in seconds; a value of zero disables them.
+	Timeout   int
+	Heartbeat int
+	
+	// Admission and Flood limit connections and packets from each client. Both
+	// can be changed by reloading the configuration.
+	Admission network.AdmissionConfig
+	Flood     network.FloodConfig
+	
+	// Capture is the directory for session captures; an empty string disables 
+	// captures.
+	Capture string
+	
+	// LogPackets prints every packet received, for debugging.
+	LogPackets bool
+	
+	// CrashReports is the directory for crash reports.
+	CrashReports string
+	
+	// Connections from TrustedProxies must start with a PROXY protocol header.
+	TrustedProxies []string
+}
+
+// Listener is an address the game server listens on, and the name of the 
+// protocol profile its clients start with (see lib/protocol).
+type Listener struct {
+	Host    string
+	Profile string
 }
 
 // Decode is called from the main function to load the server's json configuration
@@ -24,6 +82,7 @@ func (c *configuration) Decode(path string) error {
 	// Open the configuration file and read stream.	
 	file, err := os.Open(path)
 	if err != nil { return err }
+	defer file.Close()
 	reader := bufio.NewReader(file)
 	
 	// Decode the JSON file into the structure passed.
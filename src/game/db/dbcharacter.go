@@ -16,14 +16,18 @@ import (
 // flat-file database.
 var Characters characters
 type characters struct { }
-var indexlock sync.Mutex
+var indexlock, savelock sync.Mutex
 
-// Save encodes a character to JSON and saves it to the flat-file database.
+// Save encodes a character to JSON and saves it to the flat-file database. The
+// character is written to a temporary file first and then renamed over the old 
+// file, so a save interrupted by server shutdown never leaves a truncated file.
 func (_ *characters) Save(c *structures.Character) bool {
+	savelock.Lock()
+	defer savelock.Unlock()
 	
-	// BUG(Gareth): Race condition could occur with server shutdown.
-	file, err := os.Create("./characters/" + c.Name + ".json")
-	defer file.Close()
+	// Create the temporary file for the new character data.
+	path := "./characters/" + c.Name + ".json"
+	file, err := os.Create(path + ".tmp")
 	if err != nil { 
 		fmt.Printf("error: open character file for %s\n", c.Name)
 		return false
@@ -33,11 +37,30 @@ func (_ *characters) Save(c *structures.Character) bool {
 	writer := bufio.NewWriter(file)
 	encoder := json.NewEncoder(writer)
 	err = encoder.Encode(c)
-	defer writer.Flush()
+	if err == nil { err = writer.Flush() }
+	if cerr := file.Close(); err == nil { err = cerr }
+	if err != nil { fmt.Println(err); os.Remove(path + ".tmp"); return false }
+	
+	// Replace the old character file.
+	err = os.Rename(path + ".tmp", path)
 	if err != nil { fmt.Println(err); return false }
 	return true
 } 
 
+// SaveAll saves the character of every client in the connected clients pool. 
+// It's called by the game server after shutdown to make sure that no character
+// is lost if a client's disconnect event could not complete in time. Returns 
+// the number of characters which failed to save.
+func (c *characters) SaveAll() int {
+	failed := 0
+	for _, value := range Kernel.ConnectedClients.Values() {
+		client := value.(*structures.Client)
+		if client.Character != nil && !c.Save(client.Character) { failed++ }
+		Kernel.ConnectedClients.RemoveValue(client.Identity, client)
+	}
+	return failed
+}
+
 // Load opens a character file from the flat-file database after performing a
 // lookup from the character index, which maps character ids to file names.
 func (_ *characters) Load(c *structures.Client) (bool, error) {
@@ -23,10 +23,12 @@ func ProcAction(c *structures.Client, p *packets.MsgAction, b []byte) {
 }
 
 // SetLocation is called after character initialization on login to initialize
-// the location of the character. It isn't called after the login sequence.
+// the location of the character. It isn't called after the login sequence. The
+// character enters the game world once its location has been set.
 func SetLocation(c *structures.Client, p *packets.MsgAction) {
 	p.X = c.Character.X
 	p.Y = c.Character.Y
 	p.Data = c.Character.Map
 	c.Send(p)
+	c.SetState(structures.STATE_INWORLD)
 }
\ No newline at end of file
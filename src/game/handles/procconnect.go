@@ -1,13 +1,17 @@
 package handles
 
 import (
-	"encoding/gob"
+	"crypto/subtle"
 	"fmt"
 	"game/db"
+	"lib/backend"
+	"lib/network"
 	"lib/packets"
+	"lib/protocol"
 	"lib/structures"
 	"net"
 	"strings"
+	"time"
 )
 
 // ProcConnect initializes the game client after the session has been
@@ -17,34 +21,68 @@ import (
 // sequence will be interrupted for character creation.
 func ProcConnect(c *structures.Client, p *packets.MsgConnect) {
 
+	// Select the protocol profile for the client's patch. The listener's cipher
+	// has already been started, so the profile must be compatible with it.
+	profile, patch, err := protocol.Select(p.Version)
+	if err == nil && c.Profile != nil && !profile.Compatible(c.Profile) {
+		err = fmt.Errorf("patch %d requires the %s profile", patch, profile.Name)
+	}
+	if err != nil {
+		fmt.Printf("rejected client version %q from %s: %s\n", p.Version, 
+			c.RemoteAddr(), err)
+		c.Disconnect()
+		return
+	}
+	c.Profile, c.Patch = profile, patch
+
 	// Does the client exist in the authentication pool?
-	if db.Kernel.AuthenticatedClients.Contains(p.Identity) {
+	if value := db.Kernel.AuthenticatedClients.Get(p.Identity); value != nil {
 		
 		// Pull the transfer structure from the authentication pool.
-		t := db.Kernel.AuthenticatedClients.Get(p.Identity).(*structures.Transfer)
-		transferip := t.IPAddress[0:strings.Index(t.IPAddress, ":")]
-		clientip := c.Connection.RemoteAddr().String()[0:strings.Index(
-			c.Connection.RemoteAddr().String(), ":")]
+		t := value.(*structures.Transfer)
+		transferip, _, _ := net.SplitHostPort(t.IPAddress)
+		clientip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
 		
-		// Verify that the origins of the requests are the same.
-		if strings.Compare(transferip, clientip) == 0 {
-			c.Account = &t.Account
-		} else { c.Connection.Close(); return }
-	} else { c.Connection.Close(); return }
-	db.Kernel.AuthenticatedClients.Remove(p.Identity)
+		// Verify that the origins of the requests are the same, then claim the
+		// transfer. Tokens are one-time: the transfer is claimed even if the 
+		// token is wrong, so tokens can't be guessed.
+		if strings.Compare(transferip, clientip) != 0 { c.Disconnect(); return }
+		if !db.Kernel.AuthenticatedClients.RemoveValue(p.Identity, t) { 
+			c.Disconnect()
+			return 
+		}
+		if subtle.ConstantTimeEq(int32(t.Token), int32(p.Token)) != 1 {
+			fmt.Printf("rejected transfer for %d from %s: invalid token\n", 
+				p.Identity, clientip)
+			c.Disconnect()
+			return
+		} else if time.Since(t.Received) > TransferWindow() {
+			fmt.Printf("rejected transfer for %d from %s: expired\n", 
+				p.Identity, clientip)
+			c.Disconnect()
+			return
+		}
+		c.Account = &t.Account
+	} else { c.Disconnect(); return }
 
 	// Does an observer with the same account already exist on the server?
 	observer := db.Kernel.ConnectedClients.Remove(p.Identity)
-	if observer != nil { observer.(*structures.Client).Connection.Close() }
+	if observer != nil { observer.(*structures.Client).Disconnect() }
 	if db.Kernel.ConnectedClients.Add(p.Identity, c) {
 
 		// Generate keys for the client.
 		c.Identity = p.Identity
-		c.Cipher.Generate(p.Token, p.Identity)
+		err := c.Rekey(func() error { 
+			c.Cipher.Generate(p.Token, p.Identity)
+			return nil 
+		})
+		if err != nil { c.Disconnect(); return }
+		c.SetState(structures.STATE_KEYED)
 
 		// Does the player's character exist?
 		exists, err := db.Characters.Load(c)
 		if exists {
+			c.SetState(structures.STATE_LOGGINGIN)
 			c.Send(packets.NewMsgTalk(p.Identity, "SYSTEM",
 				"ALLUSERS", "ANSWER_OK", packets.MSGTALK_REGISTRATION))
 
@@ -75,6 +113,7 @@ func ProcConnect(c *structures.Client, p *packets.MsgConnect) {
 
 		} else if err == nil {
 			db.Kernel.CharacterCreationPool.Add(c.Identity, nil)
+			c.SetState(structures.STATE_CREATING)
 			c.Send(packets.NewMsgTalk(p.Identity, "SYSTEM",
 				"ALLUSERS", "NEW_ROLE", packets.MSGTALK_REGISTRATION))
 		} else {
@@ -82,48 +121,94 @@ func ProcConnect(c *structures.Client, p *packets.MsgConnect) {
 			c.Send(packets.NewMsgTalk(p.Identity, "SYSTEM",
 				"ALLUSERS", "Database error", packets.MSGTALK_REGISTRATION))
 		}
-	} else { c.Connection.Close() }
+	} else { c.Disconnect() }
 }
 
 // OpenAuthenticationChannel accepts a connection from the whitelisted account
 // server, then receives the account information for authenticated players as 
 // they login. The authentication channel helps cover a security vulnerability 
 // where a player can impersonate another player. If you consider removing this
-// system, recall that encryption is not a form of authentication. 
+// system, recall that encryption is not a form of authentication. The account
+// server must authenticate with the shared backend secret, even from the 
+// whitelisted address, and transfers are sent in encrypted envelopes. The game 
+// server's status is reported over the same channel while it's connected.
 func OpenAuthenticationChannel() {
 
 	// Listen for a new connection from the account server.
+	proxies, err := network.ParseNetworks(db.Configuration.TrustedProxies)
+	if err != nil { fmt.Println(err.Error()); return }
 	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d",
 		db.Configuration.AuthPort))
 	if err != nil { fmt.Println(err.Error()); return }
 	defer listener.Close()
 
 	for { // Reconnect to the account server on failure.
-		// Accept the new connection
-		connection, err := listener.Accept()
-		if err != nil { fmt.Println(err.Error())
+		// Accept the new connection, reading the PROXY header if proxied.
+		raw, err := listener.Accept()
+		if err != nil { fmt.Println(err.Error()); continue }
+		connection, err := network.AcceptProxy(raw, proxies)
+		if err != nil { 
+			raw.Close()
+			if err != network.ErrProxyLocal { fmt.Println(err.Error()) }
 		} else { // Check if the connection is whitelisted.
 
 			whitelisted := db.Configuration.AuthHost
-			remote := connection.RemoteAddr().String()[
-				0:strings.Index(connection.RemoteAddr().String(), ":")]
-			if strings.Compare(remote, whitelisted) == 0 {
+			remote, _, _ := net.SplitHostPort(connection.RemoteAddr().String())
+			if strings.Compare(remote, whitelisted) != 0 {
+				fmt.Printf("rejected backend connection from %s\n", remote)
+			} else if channel, err := backend.Server(connection, 
+				[]byte(db.Configuration.BackendSecret)); err != nil {
+				fmt.Printf("rejected backend connection from %s: %s\n", remote, err)
+			} else {
 
 				fmt.Println("Connection established with account server")
+				done := make(chan struct{})
+				go ReportStatus(channel, done)
 				for { // Receive transfers from the connection.
-					decoder := gob.NewDecoder(connection)
-					transfer := &structures.Transfer{}
-					err := decoder.Decode(transfer)
+					envelope, err := channel.Receive()
 					if err != nil {
 						fmt.Println("Disconnected from account server!")
 						break
 					}
+					if envelope.Type != backend.MESSAGE_TRANSFER { continue }
+					transfer := &structures.Transfer{}
+					if err := envelope.Decode(transfer); err != nil {
+						fmt.Println(err)
+						continue
+					}
 
-					// Add the transfer to the accepted connections pool.
+					// Add the transfer to the accepted connections pool, replacing 
+					// any earlier transfer for the account.
+					transfer.Received = time.Now()
+					db.Kernel.AuthenticatedClients.Remove(transfer.Account.Identity)
 					db.Kernel.AuthenticatedClients.Add(
 						transfer.Account.Identity, transfer)
 				}
+				close(done)
 			}
+			connection.Close()
 		}
 	}
 }
+
+// SweepTransfers removes transfers from the authentication pool which weren't 
+// claimed by a client within the transfer window, such as when the player closes
+// the client before it connects to the game server. It's started on its own go 
+// routine by the game server, and runs until the program exits.
+func SweepTransfers() {
+	window := TransferWindow()
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for range ticker.C {
+		db.Kernel.AuthenticatedClients.RemoveIf(func(_, value interface{}) bool {
+			return time.Since(value.(*structures.Transfer).Received) > window
+		})
+	}
+}
+
+// TransferWindow returns the time a client has to connect to the game server 
+// after its transfer arrives, from the server's configuration.
+func TransferWindow() time.Duration {
+	if db.Configuration.TransferWindow <= 0 { return structures.TRANSFER_WINDOW }
+	return time.Duration(db.Configuration.TransferWindow) * time.Second
+}
@@ -13,9 +13,30 @@ import (
 func ProcItem(c *structures.Client, p *packets.MsgItem, b []byte) {
 	switch (p.Action) {
 	
+	case packets.ITEM_PING:	Ping(c, p)
+	
 	default:
 		fmt.Println("Missing packet handle:", p.Identifier, "length", p.Length)
 		fmt.Println(hex.Dump(b))
 		c.Send(p)
 	}
-}
\ No newline at end of file
+}
+
+// Ping is sent by the client every few seconds as a heartbeat, and is echoed back 
+// by the server. The server's own heartbeat also sends pings to the client; the
+// client's echo of those pings is used to measure the client's latency.
+func Ping(c *structures.Client, p *packets.MsgItem) {
+	if c.Pong(p.Timestamp) { c.Send(p) }
+}
+
+// Heartbeat is called by the game server's heartbeat to ping the client. The 
+// ping's timestamp is matched against the client's echo to measure latency.
+func Heartbeat(c *structures.Client) {
+	timestamp, ok := c.Ping()
+	if !ok { return }
+	p := packets.NewMsgItem()
+	p.Identity = c.Identity
+	p.Action = packets.ITEM_PING
+	p.Timestamp = timestamp
+	c.Send(p)
+}
@@ -20,21 +20,15 @@ var hairstyles []uint16 = []uint16{
 // ProcRegister is sent by the game client to request character creation. The 
 // character name, body, class ,etc. should be verified before saving the 
 // character to the file system. This patch disconnects after creating the 
-// character. More recent patches around 5300+ resend the MsgConnectEx packet.
+// character. More recent patches around 5300+ resend the MsgConnectEx packet. The
+// dispatcher only accepts this packet while the client is awaiting creation.
 func ProcRegister(client *structures.Client, p *packets.MsgRegister) {
 
-	// Is this a flood attack?
-	if client.Character != nil || 
-		!db.Kernel.CharacterCreationPool.Contains(client.Identity) {
-		client.Connection.Close()
-		return	
-	}
-	
 	// Validate input from the player.
 	if (p.Model != 2001 && p.Model != 2002 && p.Model != 1003 && p.Model != 1004) ||
 	    (p.Class != 10 && p.Class != 20 && p.Class != 40 && p.Class != 100) ||
 		client.Identity != p.Identity {	
-		client.Connection.Close()
+		client.Disconnect()
 		return
 	}
 
@@ -103,8 +97,9 @@ func ProcRegister(client *structures.Client, p *packets.MsgRegister) {
 		fmt.Println("error: failed to save character file")
 	}
 
-	// Respond to the client.
+	// Respond to the client. The client disconnects after character creation.
 	db.Kernel.CharacterCreationPool.Remove(client.Identity)
+	client.SetState(structures.STATE_DISCONNECTING)
 	client.Send(packets.NewMsgTalk(p.Identity, "SYSTEM", "ALLUSERS",
 		"ANSWER_OK", packets.MSGTALK_ENTRANCE))
 }
@@ -0,0 +1,79 @@
+package handles
+
+import (
+	"game/db"
+	"lib/backend"
+	"lib/structures"
+	"sync"
+	"time"
+)
+
+// Definitions for status reports. SERVER_VERSION is reported to the account
+// server with the game server's status. STATUS_INTERVAL is the default time
+// between reports.
+const (
+	SERVER_VERSION  = "1.0"
+	STATUS_INTERVAL = 10 * time.Second
+)
+
+// status holds the settings reported to the account server, which can change
+// while the server is running. Changed is signaled when they change, so the new
+// status is reported without waiting for the next interval.
+var status struct {
+	lock        sync.Mutex
+	capacity    int
+	maintenance bool
+	changed     chan struct{}
+}
+
+func init() { status.changed = make(chan struct{}, 1) }
+
+// ConfigureStatus sets the game server's capacity and maintenance flag, which are
+// reported to the account server. If either has changed, the status is reported
+// right away.
+func ConfigureStatus(capacity int, maintenance bool) {
+	status.lock.Lock()
+	changed := status.capacity != capacity || status.maintenance != maintenance
+	status.capacity = capacity
+	status.maintenance = maintenance
+	status.lock.Unlock()
+	if !changed { return }
+	select {
+	case status.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the game server's current status. The status's interval is set
+// by ReportStatus.
+func Status() structures.ServerStatus {
+	status.lock.Lock()
+	defer status.lock.Unlock()
+	return structures.ServerStatus {
+		Online: db.Kernel.ConnectedClients.Count(),
+		Capacity: status.capacity,
+		Accepting: !status.maintenance,
+		Version: SERVER_VERSION,
+	}
+}
+
+// ReportStatus sends the game server's status to the account server over the
+// backend channel every StatusInterval seconds, and whenever the status is
+// reconfigured. It's started on its own go routine once the account server has
+// connected, and runs until done is closed or the status can't be sent.
+func ReportStatus(channel *backend.Conn, done <-chan struct{}) {
+	interval := time.Duration(db.Configuration.StatusInterval) * time.Second
+	if interval <= 0 { interval = STATUS_INTERVAL }
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report := Status()
+		report.Interval = interval
+		if err := channel.Send(backend.MESSAGE_STATUS, report); err != nil { return }
+		select {
+		case <-done: return
+		case <-ticker.C:
+		case <-status.changed:
+		}
+	}
+}
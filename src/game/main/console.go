@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// console reads commands from the operator on standard input until the input is
+// closed. Hangup signals aren't delivered on Windows, so the reload command is
+// the only way to reload the configuration there. The reload command calls 
+// reload.
+func console(reload func()) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 { continue }
+		switch command := strings.ToLower(fields[0]); {
+		case command == "reload" && len(fields) == 1: reload()
+		case command == "help":
+			fmt.Println("reload              reloads the admission lists, flood limits,")
+			fmt.Println("                    capacity, and maintenance flag")
+		default: fmt.Printf("unknown command %q, see help\n", scanner.Text())
+		}
+	}
+}
@@ -1,79 +1,113 @@
 package main
 
 import (
+	"bytes"
 	"game/db"
 	"game/handles"
-	"bytes"
-	"encoding/binary"
-	"encoding/hex"
 	"fmt"
+	"lib/network"
+	"lib/protocol"
+	"lib/security"
 	"lib/structures"
 	"lib/packets"
-	"lib/security"
+	"time"
 )
 
 // OnConnect is called by the game server to initialize the client structure upon
-// connection. Client ciphers should be initialized here. If the server has been 
-// upgraded to use the DH exchange (after 5017), then the exchange may be 
-// initialized here as well. In addition, the OnExchange event will need to be 
-// defined.
+// connection. Client ciphers are initialized here from the listener's protocol 
+// profile. Profiles which use the DH exchange (after 5017) start the exchange 
+// here by sending the server's request, which the client answers before 
+// sending MsgConnect.
 func OnConnect(client *structures.Client) {
-	client.Cipher = new(security.TQCipher)
+	client.Cipher = client.Profile.NewCipher()
 	client.Cipher.Init()
+	if client.Profile.Exchange == protocol.EXCHANGE_DH {
+		exchange, err := protocol.NewExchange()
+		if err != nil { fmt.Println(err); client.Disconnect(); return }
+		client.Exchange = exchange
+		client.SendBytes(exchange.Request())
+	}
+}
+
+// OnExchange is called by the game server with the client's response to the key
+// exchange started by OnConnect. The response contains the client's public key, 
+// which keys the client's Blowfish cipher for the rest of the session. The cipher
+// is rekeyed by the client's writer, after the exchange request has been sent.
+func OnExchange(client *structures.Client, buffer *bytes.Buffer) {
+	cipher, ok := client.Cipher.(*security.Blowfish)
+	if !ok || client.Exchange == nil { client.Disconnect(); return }
+	exchange := client.Exchange
+	if err := client.Rekey(func() error {
+		return exchange.Complete(buffer.Bytes(), cipher)
+	}); err != nil {
+		fmt.Printf("key exchange failed for %s: %s\n", client.RemoteAddr(), err)
+		client.Disconnect()
+		return
+	}
+	client.Exchange = nil
 }
 
-// OnReceive is called by the auth server to handle packets from the player's
-// game client. At this point, the server has assembled fragments and split the 
-// packet buffer into multiple packets. This function accepts a decrypted packet 
-// to be processed in the passed buffer.
-func OnReceive(client *structures.Client, buffer *bytes.Buffer) {
-	b := buffer.Bytes()
-	length := binary.LittleEndian.Uint16(b[0:2])
-	identity := binary.LittleEndian.Uint16(b[2:4])
-	switch identity {
-		
+// NewDispatcher creates the packet dispatcher used as the game server's OnReceive
+// event. At this point, the server has assembled fragments and split the packet 
+// buffer into multiple packets. The dispatcher decodes each decrypted packet and
+// calls the handler registered for its identifier below, if the packet is legal
+// in the client's state.
+func NewDispatcher(logging bool) *network.Dispatcher {
+	d := network.NewDispatcher()
+	d.Use(network.Timing(100 * time.Millisecond))
+	if logging { d.Use(network.Logging) }
+	
 	/* 1001: MsgRegister */ 
-	case packets.MSGREGISTER:
-		packet := new(packets.MsgRegister)
-		err := packets.Read(buffer, packet)
-		if err != nil { fmt.Println(err) } else { 
-			handles.ProcRegister(client, packet) 
-		}
+	d.Handle(packets.MSGREGISTER, new(packets.MsgRegister), 
+		func(c *structures.Client, p interface{}, b []byte) {
+			handles.ProcRegister(c, p.(*packets.MsgRegister))
+		}, structures.STATE_CREATING)
 	/* 1009: MsgItem */ 
-	case packets.MSGITEM:
-		packet := new(packets.MsgItem)
-		err := packets.Read(buffer, packet)
-		if err != nil { fmt.Println(err) } else { 
-			handles.ProcItem(client, packet, b) 
-		}
+	d.Handle(packets.MSGITEM, new(packets.MsgItem), 
+		func(c *structures.Client, p interface{}, b []byte) {
+			handles.ProcItem(c, p.(*packets.MsgItem), b)
+		}, structures.STATE_LOGGINGIN, structures.STATE_INWORLD)
 	/* 1010: MsgAction */ 
-	case packets.MSGACTION:
-		packet := new(packets.MsgAction)
-		err := packets.Read(buffer, packet)
-		if err != nil { fmt.Println(err) } else { 
-			handles.ProcAction(client, packet, b) 
-		}
+	d.Handle(packets.MSGACTION, new(packets.MsgAction), 
+		func(c *structures.Client, p interface{}, b []byte) {
+			handles.ProcAction(c, p.(*packets.MsgAction), b)
+		}, structures.STATE_LOGGINGIN, structures.STATE_INWORLD)
 	/* 1052: MsgConnect */ 
-	case packets.MSGCONNECT:
-		packet := new(packets.MsgConnect)
-		err := packets.Read(buffer, packet)
-		if err != nil { fmt.Println(err) } else { 
-			handles.ProcConnect(client, packet) 
-		}
-	default:
-		fmt.Println("Missing packet handle:", identity, "length", length)
-		fmt.Println(hex.Dump(b))
+	d.Handle(packets.MSGCONNECT, new(packets.MsgConnect), 
+		func(c *structures.Client, p interface{}, b []byte) {
+			handles.ProcConnect(c, p.(*packets.MsgConnect))
+		}, structures.STATE_CONNECTED, structures.STATE_KEYED)
+	return d
+}
+
+// OnFlood is called by the game server when a client is warned or kicked for 
+// sending packets faster than the flood policy allows. Characters in the game 
+// world are warned with a system message before being kicked.
+func OnFlood(client *structures.Client, identifier uint16, 
+	action network.FloodAction) {
+	if action == network.FLOOD_WARN && client.Character != nil {
+		client.Send(packets.NewMsgTalk(client.Identity, "SYSTEM", 
+			client.Character.Name, "You're sending actions too quickly.", 
+			packets.MSGTALK_TOP_LEFT))
 	}
 }
 
 // OnDisconnect is called by the game server to dispose of client structures
 // and stop in-progress actions from the client (such as trading or being a map 
-// entity) after disconnect.
+// entity) after disconnect. The character is saved before the client is removed
+// from the connected clients pool, so shutdown can wait on the pool to empty.
 func OnDisconnect(client *structures.Client) {
-	if client != nil && client.Character != nil {
-		if db.Kernel.CharacterCreationPool.Contains(client.Identity) {
-			db.Kernel.CharacterCreationPool.Remove(client.Identity)
+	if client == nil { return }
+	if client.Character != nil {
+		if !db.Characters.Save(client.Character) {
+			fmt.Printf("error: failed to save %s\n", client.Character.Name)
 		}
 		fmt.Printf("%s disconnected.\n", client.Character.Name)
 	}
-}	
+	
+	// Only remove the client if it hasn't been replaced by a new login.
+	if db.Kernel.ConnectedClients.Get(client.Identity) == client {
+		db.Kernel.CharacterCreationPool.Remove(client.Identity)
+		db.Kernel.ConnectedClients.RemoveValue(client.Identity, client)
+	}
+}
@@ -0,0 +1,259 @@
+// Backend implements the channel between the account server and game servers, 
+// which carries account transfers for players as they login, and the game 
+// servers' status reports. The account server dials each game server, then both
+// servers prove that they know the game server's shared secret with a mutual 
+// HMAC handshake before anything else is sent. Messages are then sent in 
+// versioned envelopes, encrypted and authenticated with AES-GCM using keys 
+// derived from the secret and both handshake nonces, so a peer which doesn't 
+// know the secret is rejected even if it connects from the whitelisted address,
+// and recorded messages can't be replayed on another connection.
+//
+// The handshake is three messages. The dialer sends its hello (the magic, the 
+// protocol version, and a random nonce); the listener answers with its own hello 
+// and its proof, an HMAC of both nonces; the dialer checks the listener's proof 
+// and answers with its own. Proofs are labeled by role, so one side's proof can't
+// be reflected back as the other's.
+package backend
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Definitions for the backend protocol. BACKEND_VERSION is sent in the handshake
+// and in every envelope; peers with a different version are rejected.
+const (
+	BACKEND_MAGIC     = "GOCQBKND"
+	BACKEND_VERSION   = 1
+	MINIMUM_SECRET    = 16
+	MAXIMUM_MESSAGE   = 1 << 20
+	HANDSHAKE_TIMEOUT = 10 * time.Second
+	NONCE_LENGTH      = 32
+)
+
+// EXAMPLE_SECRET is the placeholder secret from earlier example configuration 
+// files. It's public, so it's refused like a secret which is too short.
+const EXAMPLE_SECRET = "ChangeThisBackendSecret"
+
+// Message types carried in envelopes.
+const (
+	MESSAGE_TRANSFER = 1 // structures.Transfer, from the account server.
+	MESSAGE_STATUS   = 2 // structures.ServerStatus, from the game server.
+)
+
+var (
+	ErrSecret         = errors.New("backend: shared secret is too short")
+	ErrExampleSecret  = errors.New("backend: shared secret is the example " +
+		"secret, choose your own")
+	ErrHandshake      = errors.New("backend: invalid handshake")
+	ErrAuthentication = errors.New("backend: peer failed authentication")
+	ErrVersion        = errors.New("backend: unsupported protocol version")
+	ErrMessage        = errors.New("backend: invalid message")
+)
+
+// Envelope is a message received from the backend channel. The payload is the 
+// message encoded with gob, which Decode decodes.
+type Envelope struct {
+	Version uint8
+	Type    uint8
+	Payload []byte
+}
+
+// Decode decodes the envelope's payload into value.
+func (e *Envelope) Decode(value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(e.Payload)).Decode(value)
+}
+
+// Conn is an authenticated backend connection. Send may be called from multiple
+// go routines; Receive should only be called from one.
+type Conn struct {
+	connection   net.Conn
+	send         cipher.AEAD
+	receive      cipher.AEAD
+	sendcount    uint64
+	receivecount uint64
+	lock         sync.Mutex
+}
+
+// Client performs the dialer's side of the handshake over a new connection. The
+// connection is closed if the handshake fails.
+func Client(connection net.Conn, secret []byte) (*Conn, error) {
+	c, err := handshake(connection, secret, true)
+	if err != nil { connection.Close() }
+	return c, err
+}
+
+// Server performs the listener's side of the handshake over an accepted 
+// connection. The connection is closed if the handshake fails.
+func Server(connection net.Conn, secret []byte) (*Conn, error) {
+	c, err := handshake(connection, secret, false)
+	if err != nil { connection.Close() }
+	return c, err
+}
+
+// CheckSecret returns an error if the shared secret can't be used: if it's 
+// shorter than MINIMUM_SECRET, or if it's the example secret. Servers check 
+// their secrets when they're loaded, so they refuse to start without one.
+func CheckSecret(secret []byte) error {
+	if len(secret) < MINIMUM_SECRET { return ErrSecret }
+	if string(secret) == EXAMPLE_SECRET { return ErrExampleSecret }
+	return nil
+}
+
+// Dial connects to a game server's backend address and performs the handshake.
+func Dial(address string, secret []byte) (*Conn, error) {
+	if err := CheckSecret(secret); err != nil { return nil, err }
+	connection, err := net.DialTimeout("tcp", address, HANDSHAKE_TIMEOUT)
+	if err != nil { return nil, err }
+	return Client(connection, secret)
+}
+
+// handshake exchanges hellos and proofs with the peer, then derives the keys for
+// each direction from the secret and both nonces.
+func handshake(connection net.Conn, secret []byte, dialer bool) (*Conn, error) {
+	if err := CheckSecret(secret); err != nil { return nil, err }
+	connection.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer connection.SetDeadline(time.Time {})
+	
+	hello := make([]byte, 0, len(BACKEND_MAGIC) + 1 + NONCE_LENGTH)
+	hello = append(hello, BACKEND_MAGIC...)
+	hello = append(hello, BACKEND_VERSION)
+	nonce := make([]byte, NONCE_LENGTH)
+	if _, err := rand.Read(nonce); err != nil { return nil, err }
+	hello = append(hello, nonce...)
+	
+	var clientnonce, servernonce []byte
+	if dialer {
+		// Send the hello, then check the listener's hello and proof.
+		if _, err := connection.Write(hello); err != nil { return nil, err }
+		peer, err := readhello(connection)
+		if err != nil { return nil, err }
+		clientnonce, servernonce = nonce, peer
+		proof := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(connection, proof); err != nil { return nil, err }
+		if !hmac.Equal(proof, sign(secret, "server", clientnonce, servernonce)) {
+			return nil, ErrAuthentication
+		}
+		_, err = connection.Write(sign(secret, "client", clientnonce, servernonce))
+		if err != nil { return nil, err }
+		
+	} else {
+		// Check the dialer's hello, send the hello and proof, then check the 
+		// dialer's proof. The dialer hangs up instead if it rejects the proof.
+		peer, err := readhello(connection)
+		if err != nil { return nil, err }
+		clientnonce, servernonce = peer, nonce
+		hello = append(hello, sign(secret, "server", clientnonce, servernonce)...)
+		if _, err := connection.Write(hello); err != nil { return nil, err }
+		proof := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(connection, proof); err == io.EOF { 
+			return nil, ErrAuthentication
+		} else if err != nil { return nil, err }
+		if !hmac.Equal(proof, sign(secret, "client", clientnonce, servernonce)) {
+			return nil, ErrAuthentication
+		}
+	}
+	
+	// Derive a key for each direction.
+	salt := append(append([]byte(nil), clientnonce...), servernonce...)
+	upstream, err := newaead(secret, salt, "goconquer backend client to server")
+	if err != nil { return nil, err }
+	downstream, err := newaead(secret, salt, "goconquer backend server to client")
+	if err != nil { return nil, err }
+	c := &Conn { connection: connection }
+	if dialer { c.send, c.receive = upstream, downstream 
+	} else { c.send, c.receive = downstream, upstream }
+	return c, nil
+}
+
+// Send encodes a value with gob and sends it in an envelope of the given type.
+func (c *Conn) Send(kind uint8, value interface{}) error {
+	payload := bytes.NewBuffer([]byte { BACKEND_VERSION, kind })
+	if err := gob.NewEncoder(payload).Encode(value); err != nil { return err }
+	if payload.Len() > MAXIMUM_MESSAGE { return ErrMessage }
+	
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	nonce := counter(c.sendcount)
+	c.sendcount++
+	frame := make([]byte, 4, 4 + payload.Len() + c.send.Overhead())
+	frame = c.send.Seal(frame, nonce, payload.Bytes(), nil)
+	binary.LittleEndian.PutUint32(frame, uint32(len(frame) - 4))
+	_, err := c.connection.Write(frame)
+	return err
+}
+
+// Receive reads the next envelope from the connection. Envelopes which fail 
+// authentication, arrive out of order, or have an unsupported version are 
+// rejected with an error, after which the connection should be closed.
+func (c *Conn) Receive() (*Envelope, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.connection, header); err != nil { return nil, err }
+	length := binary.LittleEndian.Uint32(header)
+	if length < uint32(c.receive.Overhead() + 2) || 
+		length > uint32(MAXIMUM_MESSAGE + c.receive.Overhead()) {
+		return nil, ErrMessage
+	}
+	
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(c.connection, sealed); err != nil { return nil, err }
+	plain, err := c.receive.Open(sealed[:0], counter(c.receivecount), sealed, nil)
+	if err != nil { return nil, ErrAuthentication }
+	c.receivecount++
+	if plain[0] != BACKEND_VERSION { return nil, ErrVersion }
+	return &Envelope { plain[0], plain[1], plain[2:] }, nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error { return c.connection.Close() }
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr { return c.connection.RemoteAddr() }
+
+// readhello reads the peer's hello and returns its nonce.
+func readhello(r io.Reader) ([]byte, error) {
+	hello := make([]byte, len(BACKEND_MAGIC) + 1 + NONCE_LENGTH)
+	if _, err := io.ReadFull(r, hello); err != nil { return nil, err }
+	if string(hello[:len(BACKEND_MAGIC)]) != BACKEND_MAGIC { return nil, ErrHandshake }
+	if hello[len(BACKEND_MAGIC)] != BACKEND_VERSION { return nil, ErrVersion }
+	return hello[len(BACKEND_MAGIC) + 1:], nil
+}
+
+// sign returns the proof for a role: an HMAC of the role and both nonces.
+func sign(secret []byte, role string, clientnonce, servernonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(role))
+	mac.Write(clientnonce)
+	mac.Write(servernonce)
+	return mac.Sum(nil)
+}
+
+// newaead derives an AES-256 key with HKDF and returns it as AES-GCM.
+func newaead(secret, salt []byte, info string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, secret, salt, info, 32)
+	if err != nil { return nil, err }
+	block, err := aes.NewCipher(key)
+	if err != nil { return nil, err }
+	return cipher.NewGCM(block)
+}
+
+// counter returns the nonce for a message, from the number of messages sent in 
+// that direction. Each key is only used for one connection and direction, so a 
+// counter never repeats a nonce.
+func counter(count uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], count)
+	return nonce
+}
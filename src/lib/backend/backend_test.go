@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"net"
+	"testing"
+)
+
+type message struct {
+	Name  string
+	Value int
+}
+
+// pair runs both sides of the handshake over a pipe, with the dialer's and 
+// listener's secrets.
+func pair(t *testing.T, dialer, listener string) (*Conn, *Conn, error, error) {
+	a, b := net.Pipe()
+	type result struct { c *Conn; err error }
+	ch := make(chan result, 1)
+	go func() { c, err := Server(b, []byte(listener)); ch <- result { c, err } }()
+	client, cerr := Client(a, []byte(dialer))
+	r := <-ch
+	return client, r.c, cerr, r.err
+}
+
+func TestHandshake(t *testing.T) {
+	secret := "0123456789abcdef0123"
+	client, server, cerr, serr := pair(t, secret, secret)
+	if cerr != nil || serr != nil { t.Fatalf("client %v, server %v", cerr, serr) }
+	defer client.Close()
+	
+	// Send messages in both directions.
+	go client.Send(MESSAGE_TRANSFER, message { "first", 1 })
+	envelope, err := server.Receive()
+	if err != nil { t.Fatal(err) }
+	var m message
+	if err := envelope.Decode(&m); err != nil || m.Name != "first" || m.Value != 1 {
+		t.Fatalf("received %+v, %v", m, err)
+	}
+	if envelope.Version != BACKEND_VERSION || envelope.Type != MESSAGE_TRANSFER {
+		t.Fatalf("envelope version %d, type %d", envelope.Version, envelope.Type)
+	}
+	go server.Send(2, message { "second", 2 })
+	if envelope, err = client.Receive(); err != nil { t.Fatal(err) }
+	if err := envelope.Decode(&m); err != nil || m.Name != "second" { 
+		t.Fatalf("received %+v, %v", m, err) 
+	}
+}
+
+// TestHandshakeRejected checks that a peer with the wrong secret is rejected by 
+// both sides of the handshake.
+func TestHandshakeRejected(t *testing.T) {
+	_, _, cerr, serr := pair(t, "0123456789abcdef", "fedcba9876543210")
+	if cerr != ErrAuthentication { t.Errorf("dialer: got %v, want %v", cerr, 
+		ErrAuthentication) }
+	if serr != ErrAuthentication { t.Errorf("listener: got %v, want %v", serr, 
+		ErrAuthentication) }
+	
+	_, _, cerr, serr = pair(t, "short", "short")
+	if cerr != ErrSecret || serr != ErrSecret { 
+		t.Errorf("short secret: got %v, %v; want %v", cerr, serr, ErrSecret) 
+	}
+	_, _, cerr, serr = pair(t, EXAMPLE_SECRET, EXAMPLE_SECRET)
+	if cerr != ErrExampleSecret || serr != ErrExampleSecret { 
+		t.Errorf("example secret: got %v, %v; want %v", cerr, serr, 
+			ErrExampleSecret) 
+	}
+}
+
+// TestTampered checks that modified and replayed envelopes are rejected.
+func TestTampered(t *testing.T) {
+	secret := "0123456789abcdef"
+	for _, tamper := range []bool { false, true } {
+		client, server, cerr, serr := pair(t, secret, secret)
+		if cerr != nil || serr != nil { t.Fatalf("client %v, server %v", cerr, serr) }
+		
+		// Record the client's frame instead of sending it to the server.
+		record, relay := net.Pipe()
+		peer := client.connection
+		client.connection = record
+		go client.Send(MESSAGE_TRANSFER, message { "recorded", 1 })
+		frame := make([]byte, 4096)
+		n, err := relay.Read(frame)
+		if err != nil { t.Fatal(err) }
+		frame = frame[:n]
+		
+		if tamper { // Flip a bit of the ciphertext.
+			frame[len(frame) - 1] ^= 1
+			go peer.Write(frame)
+			if _, err := server.Receive(); err != ErrAuthentication {
+				t.Errorf("tampered: got %v, want %v", err, ErrAuthentication)
+			}
+		} else { // Deliver the frame twice.
+			go func() { peer.Write(frame); peer.Write(frame) }()
+			if _, err := server.Receive(); err != nil { t.Fatalf("first: %v", err) }
+			if _, err := server.Receive(); err != ErrAuthentication { 
+				t.Errorf("replay: got %v, want %v", err, ErrAuthentication) 
+			}
+		}
+		peer.Close()
+	}
+}
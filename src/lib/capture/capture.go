@@ -0,0 +1,139 @@
+// Capture records the decrypted packets of a client's session to a file, so the
+// session can be inspected or replayed against a server later. A capture file 
+// is written in NetDragon's byte order (little endian) and has this format:
+//
+//	Header:
+//	  [8]byte   magic, "GOCQCAP1"
+//	  int64     session start time, in nanoseconds since the Unix epoch
+//	  uint8     length of the remote address
+//	  []byte    remote address of the client (host:port)
+//	Records, repeated until the end of the file:
+//	  uint8     direction (0 = inbound from client, 1 = outbound to client)
+//	  int64     time of the record, in nanoseconds since the session started
+//	  uint16    length of the packet
+//	  []byte    the decrypted packet, including its header
+//
+// Packets are recorded as they are received from the client (after decryption)
+// and as they are written to the client (without encryption).
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const CAPTURE_MAGIC = "GOCQCAP1"
+
+// Record directions.
+const (
+	INBOUND  byte = 0
+	OUTBOUND byte = 1
+)
+
+// Record is a single packet read from a capture file.
+type Record struct {
+	Direction byte
+	Offset    time.Duration
+	Data      []byte
+}
+
+// Writer records packets for a single session. It's safe for concurrent use, 
+// since packets are received and sent on different go routines.
+type Writer struct {
+	file    *os.File
+	started time.Time
+	lock    sync.Mutex
+}
+
+// Create creates a new capture file in the directory for a session with the 
+// remote address. The file is named after the session's start time and address.
+// The directory is created if it doesn't exist.
+func Create(directory, address string) (*Writer, error) {
+	if err := os.MkdirAll(directory, 0770); err != nil { return nil, err }
+	started := time.Now()
+	name := fmt.Sprintf("%s-%s.cap", started.Format("20060102-150405.000000000"),
+		strings.NewReplacer(":", "_", "[", "", "]", "").Replace(address))
+	file, err := os.Create(filepath.Join(directory, name))
+	if err != nil { return nil, err }
+	
+	// Write the header to the file.
+	header := make([]byte, 17, 17 + len(address))
+	copy(header[0:8], CAPTURE_MAGIC)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(started.UnixNano()))
+	header[16] = byte(len(address))
+	header = append(header, address[:int(header[16])]...)
+	if _, err := file.Write(header); err != nil { file.Close(); return nil, err }
+	return &Writer { file: file, started: started }, nil
+}
+
+// Record appends a packet to the capture file. Errors are ignored, since a
+// capture should never interrupt the session it's recording.
+func (w *Writer) Record(direction byte, data []byte) {
+	record := make([]byte, 11 + len(data))
+	record[0] = direction
+	binary.LittleEndian.PutUint64(record[1:9], uint64(time.Since(w.started)))
+	binary.LittleEndian.PutUint16(record[9:11], uint16(len(data)))
+	copy(record[11:], data)
+	
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file != nil { w.file.Write(record) }
+}
+
+// Close closes the capture file. Records after closing are discarded.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil { return nil }
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Reader reads records from a capture file.
+type Reader struct {
+	Started time.Time
+	Address string
+	reader  *bufio.Reader
+}
+
+// NewReader reads the capture header from r and returns a reader for its records.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, 17)
+	if _, err := io.ReadFull(reader, header); err != nil { return nil, err }
+	if string(header[0:8]) != CAPTURE_MAGIC {
+		return nil, errors.New("capture.NewReader: not a capture file")
+	}
+	address := make([]byte, header[16])
+	if _, err := io.ReadFull(reader, address); err != nil { return nil, err }
+	return &Reader { 
+		Started: time.Unix(0, int64(binary.LittleEndian.Uint64(header[8:16]))), 
+		Address: string(address), 
+		reader:  reader }, nil
+}
+
+// Next returns the next record in the capture file, or io.EOF after the last.
+func (r *Reader) Next() (*Record, error) {
+	header := make([]byte, 11)
+	if _, err := io.ReadFull(r.reader, header); err != nil { 
+		if err == io.ErrUnexpectedEOF { err = errors.New("capture: truncated record") }
+		return nil, err 
+	}
+	record := &Record { 
+		Direction: header[0],
+		Offset:    time.Duration(binary.LittleEndian.Uint64(header[1:9])),
+		Data:      make([]byte, binary.LittleEndian.Uint16(header[9:11])) }
+	if _, err := io.ReadFull(r.reader, record.Data); err != nil {
+		return nil, errors.New("capture: truncated record")
+	}
+	return record, nil
+}
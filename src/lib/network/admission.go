@@ -0,0 +1,141 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdmissionConfig is decoded from the server's configuration file. It limits 
+// the number of concurrent connections from a single IP address and the rate at
+// which the server accepts new connections (per second, with a burst). Allow and
+// Deny are lists of CIDR blocks or single IP addresses. If the allow list isn't
+// empty, only addresses in the allow list are accepted. Addresses in the deny 
+// list are always rejected. A zero limit or rate disables that check.
+type AdmissionConfig struct {
+	MaxConnectionsPerIP int
+	AcceptRate          int
+	AcceptBurst         int
+	Allow, Deny         []string
+}
+
+var ErrAdmissionDenied = errors.New("address is in the deny list")
+var ErrAdmissionNotAllowed = errors.New("address is not in the allow list")
+var ErrAdmissionLimit = errors.New("too many connections from address")
+
+// Admission decides which connections the server accepts. It's checked by the 
+// server's listener before a go routine is created for the new connection, and
+// can be reconfigured at runtime (such as when reloading the configuration file)
+// without dropping existing connections.
+type Admission struct {
+	lock        sync.Mutex
+	limit       int
+	allow, deny []*net.IPNet
+	bucket      *TokenBucket
+	connections map[string]int
+}
+
+// NewAdmission creates the admission layer from the server's configuration.
+func NewAdmission(config AdmissionConfig) (*Admission, error) {
+	a := &Admission { connections: make(map[string]int) }
+	if err := a.Configure(config); err != nil { return nil, err }
+	return a, nil
+}
+
+// Configure replaces the admission layer's limits and CIDR lists. If any of the 
+// lists can't be parsed, the current configuration is kept and an error is 
+// returned. Connection counts for existing clients are preserved.
+func (a *Admission) Configure(config AdmissionConfig) error {
+	allow, err := parseNetworks(config.Allow)
+	if err != nil { return err }
+	deny, err := parseNetworks(config.Deny)
+	if err != nil { return err }
+	
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.limit = config.MaxConnectionsPerIP
+	a.allow = allow
+	a.deny = deny
+	a.bucket = nil
+	if config.AcceptRate > 0 {
+		burst := config.AcceptBurst
+		if burst < config.AcceptRate { burst = config.AcceptRate }
+		a.bucket = NewTokenBucket(float64(config.AcceptRate), burst)
+	}
+	return nil
+}
+
+// Admit checks the remote address of a new connection against the deny list, 
+// the allow list, and the per-IP connection limit. If the connection is 
+// admitted, it's counted against its address until Release is called. Else, an
+// error describing the reason for rejection is returned.
+func (a *Admission) Admit(addr net.Addr) error {
+	host := hostname(addr)
+	ip := net.ParseIP(host)
+	
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if ip != nil && containsIP(a.deny, ip) { return ErrAdmissionDenied }
+	if len(a.allow) > 0 && (ip == nil || !containsIP(a.allow, ip)) {
+		return ErrAdmissionNotAllowed
+	}
+	if a.limit > 0 && a.connections[host] >= a.limit { return ErrAdmissionLimit }
+	a.connections[host]++
+	return nil
+}
+
+// Release is called once an admitted connection has been closed.
+func (a *Admission) Release(addr net.Addr) {
+	host := hostname(addr)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.connections[host] <= 1 { 
+		delete(a.connections, host) 
+	} else { a.connections[host]-- }
+}
+
+// Throttle blocks the listener until the accept rate allows another connection.
+func (a *Admission) Throttle() {
+	for {
+		a.lock.Lock()
+		if a.bucket == nil || a.bucket.Take() { a.lock.Unlock(); return }
+		delay := a.bucket.Delay()
+		a.lock.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// parseNetworks parses a list of CIDR blocks. Single addresses are accepted as
+// well, and converted into a block containing only that address.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(list))
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil { return nil, fmt.Errorf("invalid address %q", entry) }
+			if ip.To4() != nil { entry += "/32" } else { entry += "/128" }
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil { return nil, err }
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// containsIP returns true if any of the networks contains the IP address.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) { return true }
+	}
+	return false
+}
+
+// hostname returns the IP address portion of a remote address.
+func hostname(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil { return addr.String() }
+	return host
+}
@@ -0,0 +1,43 @@
+package network
+
+import "time"
+
+// TokenBucket is a simple rate limiter. The bucket holds up to Burst tokens and 
+// is refilled at Rate tokens per second. Each action takes a token from the 
+// bucket; if the bucket is empty, the action is over the limit. The bucket isn't
+// thread-safe and should be guarded by its owner's lock if shared.
+type TokenBucket struct {
+	Rate, Burst float64
+	tokens      float64
+	last        time.Time
+}
+
+// NewTokenBucket creates a full token bucket with the specified rate and burst.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 { burst = 1 }
+	return &TokenBucket { Rate: rate, Burst: float64(burst), 
+		tokens: float64(burst), last: time.Now() }
+}
+
+// Take removes a token from the bucket. Returns false if the bucket is empty.
+func (b *TokenBucket) Take() bool {
+	b.refill()
+	if b.tokens < 1 { return false }
+	b.tokens--
+	return true
+}
+
+// Delay returns the time remaining until the next token is available.
+func (b *TokenBucket) Delay() time.Duration {
+	b.refill()
+	if b.tokens >= 1 || b.Rate <= 0 { return 0 }
+	return time.Duration((1 - b.tokens) / b.Rate * float64(time.Second))
+}
+
+// refill adds tokens to the bucket for the time passed since the last refill.
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.Rate
+	if b.tokens > b.Burst { b.tokens = b.Burst }
+	b.last = now
+}
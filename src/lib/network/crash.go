@@ -0,0 +1,107 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"lib/structures"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// crash is the panic value re-raised by the receive loop when the OnReceive event
+// panics. It carries the packet being processed and the stack of the original
+// panic up to the connection's Accept function, which writes the crash report.
+type crash struct {
+	value  interface{}
+	packet []byte
+	stack  []byte
+	state  structures.ClientState
+}
+
+// process calls the OnReceive event for a packet. If the event panics, the panic
+// is re-raised with the packet attached for the crash report.
+func (s *Server) process(client *structures.Client, packet []byte) {
+	defer func() {
+		if r := recover(); r != nil { 
+			panic(&crash { value: r, packet: packet, stack: debug.Stack(), 
+				state: client.State() }) 
+		}
+	}()
+	s.OnReceive(client, bytes.NewBuffer(packet))
+}
+
+// recover is deferred by the Accept function, and by the client's heartbeat go 
+// routine, to isolate panics to the client's connection. It writes a crash 
+// report and disconnects only that client.
+func (s *Server) recover(client *structures.Client) {
+	r := recover()
+	if r == nil { return }
+	report, ok := r.(*crash)
+	if !ok { 
+		report = &crash { value: r, stack: debug.Stack(), state: client.State() } 
+	}
+	s.crashed(client, report)
+}
+
+// recovered is called by the client's writer go routine after it recovers from
+// a panic, such as in the client's cipher.
+func (s *Server) recovered(client *structures.Client, value interface{}, 
+	stack []byte) {
+	report := &crash { value: value, stack: stack, state: client.State() }
+	s.crashed(client, report)
+}
+
+// crashed counts a recovered panic, disconnects the client, and reports it.
+func (s *Server) crashed(client *structures.Client, report *crash) {
+	s.mutex.Lock()
+	s.panics++
+	s.mutex.Unlock()
+	client.Disconnect()
+	s.report(client, report)
+}
+
+// report writes a crash report for a recovered panic to the server's crash 
+// report directory, or prints it if there's no directory.
+func (s *Server) report(client *structures.Client, c *crash) {
+	now := time.Now()
+	buffer := bytes.NewBuffer(nil)
+	fmt.Fprintf(buffer, "Time: %s\r\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(buffer, "Panic: %v\r\n", c.value)
+	fmt.Fprintf(buffer, "Client: %s, identity %d, %s\r\n", 
+		client.RemoteAddr(), client.Identity, c.state)
+	if client.Account != nil {
+		fmt.Fprintf(buffer, "Account: %s\r\n", client.Account.Username)
+	}
+	if len(c.packet) >= 4 {
+		fmt.Fprintf(buffer, "Packet: %d, length %d\r\n%s", 
+			binary.LittleEndian.Uint16(c.packet[2:4]), len(c.packet), 
+			hex.Dump(c.packet))
+	}
+	fmt.Fprintf(buffer, "Stack:\r\n%s", c.stack)
+	
+	// Write the report to a new file in the crash report directory.
+	if s.CrashReports == "" { fmt.Print(buffer.String()); return }
+	path := filepath.Join(s.CrashReports, fmt.Sprintf("crash-%s-%d.txt", 
+		now.Format("20060102-150405.000000000"), client.Identity))
+	err := os.MkdirAll(s.CrashReports, 0770)
+	if err == nil { err = os.WriteFile(path, buffer.Bytes(), 0660) }
+	if err != nil {
+		fmt.Println(err)
+		fmt.Print(buffer.String())
+		return
+	}
+	fmt.Printf("recovered panic from %s: %v (see %s)\n", 
+		client.RemoteAddr(), c.value, path)
+}
+
+// Panics returns the number of panics recovered from client connections since 
+// the server started.
+func (s *Server) Panics() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.panics
+}
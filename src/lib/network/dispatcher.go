@@ -0,0 +1,128 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"lib/packets"
+	"lib/structures"
+	"reflect"
+	"sync"
+)
+
+// Handler processes a decoded packet from the client. The packet is a pointer to
+// a new structure of the type registered for the packet's identifier. The raw 
+// buffer is passed as well, for handlers which hex dump unhandled subtypes.
+type Handler func(client *structures.Client, packet interface{}, buffer []byte)
+
+// Middleware wraps a handler to run code before and after it, such as logging 
+// or checking the client's state. The middleware may skip the handler entirely.
+type Middleware func(identifier uint16, next Handler) Handler
+
+// Dispatcher routes packets to the handlers registered for their identifiers. 
+// Its Dispatch function is used as the server's OnReceive event. Handlers must 
+// be registered before the server starts listening. Packets without a handler 
+// are counted rather than printed, since some clients send them constantly.
+type Dispatcher struct {
+	routes     map[uint16]route
+	middleware []Middleware
+	lock       sync.Mutex
+	unknown    map[uint16]uint64
+}
+
+// route is a handler registered for a packet identifier. Chain is the handler 
+// wrapped in the dispatcher's middleware, which is composed when the route is 
+// registered (or when middleware is added) rather than for every packet.
+type route struct {
+	packet  reflect.Type
+	handler Handler
+	chain   Handler
+	states  []structures.ClientState
+}
+
+// NewDispatcher creates a dispatcher without any handlers or middleware.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher { 
+		routes:  make(map[uint16]route), 
+		unknown: make(map[uint16]uint64) }
+}
+
+// Use appends middleware to the dispatcher's chain. Middleware runs in the order
+// it was added, with the first middleware being the outermost. Handlers which 
+// are already registered are wrapped in the new middleware as well.
+func (d *Dispatcher) Use(middleware ...Middleware) {
+	d.middleware = append(d.middleware, middleware...)
+	for identifier, r := range d.routes { 
+		d.routes[identifier] = d.compose(identifier, r) 
+	}
+}
+
+// Handle registers a handler for a packet identifier. The packet parameter is a 
+// pointer to the packet structure, which is used as the type to decode into 
+// (such as new(packets.MsgAccount)). The packet is only valid while the client
+// is in one of the states passed. 
+func (d *Dispatcher) Handle(identifier uint16, packet interface{}, handler Handler,
+	states ...structures.ClientState) {
+	r := route { packet: reflect.TypeOf(packet).Elem(), handler: handler, 
+		states: states }
+	d.routes[identifier] = d.compose(identifier, r)
+}
+
+// Dispatch decodes the packet in the buffer using the type registered for its 
+// identifier, then calls the handler through the middleware chain. If the client
+// isn't in a state the packet is valid in, the client is disconnected instead.
+func (d *Dispatcher) Dispatch(client *structures.Client, buffer *bytes.Buffer) {
+	b := buffer.Bytes()
+	identifier := binary.LittleEndian.Uint16(b[2:4])
+	r, exists := d.routes[identifier]
+	if !exists {
+		d.lock.Lock()
+		d.unknown[identifier]++
+		d.lock.Unlock()
+		return
+	}
+	
+	// Check that the packet is legal in the client's state.
+	state := client.State()
+	if state == structures.STATE_DISCONNECTING { return }
+	if !r.valid(state) {
+		fmt.Printf("rejected packet %d from %s: client is %s\n", identifier,
+			client.RemoteAddr(), state)
+		client.Disconnect()
+		return
+	}
+	
+	// Decode the packet into a new structure.
+	packet := reflect.New(r.packet).Interface()
+	if err := packets.ReadPatch(buffer, packet, client.Patch); err != nil { 
+		fmt.Println(err)
+		return 
+	}
+	
+	r.chain(client, packet, b)
+}
+
+// Unknown returns the number of packets received for each identifier without a 
+// registered handler.
+func (d *Dispatcher) Unknown() map[uint16]uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result := make(map[uint16]uint64, len(d.unknown))
+	for identifier, count := range d.unknown { result[identifier] = count }
+	return result
+}
+
+// compose builds the middleware chain around a route's handler.
+func (d *Dispatcher) compose(identifier uint16, r route) route {
+	r.chain = r.handler
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		r.chain = d.middleware[i](identifier, r.chain)
+	}
+	return r
+}
+
+// valid returns true if the route's packet is legal in the client's state.
+func (r *route) valid(state structures.ClientState) bool {
+	for _, s := range r.states { if s == state { return true } }
+	return false
+}
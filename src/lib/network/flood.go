@@ -0,0 +1,139 @@
+package network
+
+import (
+	"fmt"
+	"lib/structures"
+	"os"
+	"sync"
+	"time"
+)
+
+// RateLimit is the number of packets per second a client may send, with a burst
+// for packets which are sent in quick succession (such as during login).
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// FloodConfig is decoded from the server's configuration file. Limits are keyed
+// by packet identifier; packets without a limit use the default limit, unless 
+// the default rate is zero. Packets over the limit are dropped. After WarnAfter
+// dropped packets the client is warned, and after KickAfter dropped packets the 
+// client is disconnected and recorded in the Record file. The count of dropped 
+// packets is reset once the client hasn't flooded for Forgive seconds.
+type FloodConfig struct {
+	Default   RateLimit
+	Limits    map[uint16]RateLimit
+	WarnAfter int
+	KickAfter int
+	Forgive   int
+	Record    string
+}
+
+// FloodAction is the result of checking a packet against the flood policy.
+type FloodAction int
+const (
+	FLOOD_ALLOW FloodAction = iota
+	FLOOD_DROP
+	FLOOD_WARN
+	FLOOD_KICK
+)
+
+// FloodPolicy is shared by all clients of a server (and by both the account and
+// game servers). Each client's receive loop creates its own limiter from the 
+// policy, so the receive path doesn't contend on a lock for every packet.
+type FloodPolicy struct {
+	lock       sync.RWMutex
+	config     FloodConfig
+	generation int
+	record     sync.Mutex
+}
+
+// NewFloodPolicy creates the flood policy from the server's configuration.
+func NewFloodPolicy(config FloodConfig) *FloodPolicy {
+	p := new(FloodPolicy)
+	p.Configure(config)
+	return p
+}
+
+// Configure replaces the policy's limits. Limiters created from the policy reset
+// their token buckets on the next packet received.
+func (p *FloodPolicy) Configure(config FloodConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.config = config
+	p.generation++
+}
+
+// NewLimiter creates a limiter for a single client's receive loop.
+func (p *FloodPolicy) NewLimiter() *FloodLimiter {
+	return &FloodLimiter { policy: p, generation: -1 }
+}
+
+// Record appends a line for a client kicked for flooding to the record file.
+func (p *FloodPolicy) Record(client *structures.Client, identifier uint16) {
+	p.lock.RLock()
+	path := p.config.Record
+	p.lock.RUnlock()
+	if path == "" { return }
+	
+	account := "unauthenticated"
+	if client.Account != nil { 
+		account = fmt.Sprintf("%s (%d)", client.Account.Username, 
+			client.Account.Identity)
+	}
+	p.record.Lock()
+	defer p.record.Unlock()
+	file, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0660)
+	if err != nil { fmt.Println(err); return }
+	defer file.Close()
+	fmt.Fprintf(file, "%s,%s,%s,%d\r\n", time.Now().Format(time.RFC3339), 
+		client.RemoteAddr(), account, identifier)
+}
+
+// FloodLimiter holds the token buckets for a single client. It isn't thread-safe
+// and should only be used by the client's receive loop.
+type FloodLimiter struct {
+	policy     *FloodPolicy
+	generation int
+	config     FloodConfig
+	buckets    map[uint16]*TokenBucket
+	dropped    int
+	flooded    time.Time
+}
+
+// Check takes a token for the packet identifier from the client's buckets, and
+// returns the action the server should take for the packet.
+func (l *FloodLimiter) Check(identifier uint16) FloodAction {
+	l.policy.lock.RLock()
+	if l.generation != l.policy.generation {
+		l.config = l.policy.config
+		l.generation = l.policy.generation
+		l.buckets = make(map[uint16]*TokenBucket)
+	}
+	l.policy.lock.RUnlock()
+	
+	// Find or create the token bucket for the identifier.
+	bucket, exists := l.buckets[identifier]
+	if !exists {
+		limit, exists := l.config.Limits[identifier]
+		if !exists { limit = l.config.Default }
+		if limit.Rate > 0 { bucket = NewTokenBucket(limit.Rate, limit.Burst) }
+		l.buckets[identifier] = bucket
+	}
+	if bucket == nil || bucket.Take() { return FLOOD_ALLOW }
+	
+	// The packet is over the limit. Forgive old floods before counting it.
+	now := time.Now()
+	forgive := time.Duration(l.config.Forgive) * time.Second
+	if forgive > 0 && now.Sub(l.flooded) > forgive { l.dropped = 0 }
+	l.flooded = now
+	l.dropped++
+	switch {
+	case l.config.KickAfter > 0 && l.dropped >= l.config.KickAfter: 
+		return FLOOD_KICK
+	case l.config.WarnAfter > 0 && l.dropped == l.config.WarnAfter: 
+		return FLOOD_WARN
+	}
+	return FLOOD_DROP
+}
@@ -0,0 +1,31 @@
+package network
+
+import (
+	"fmt"
+	"lib/structures"
+	"time"
+)
+
+// Logging prints every packet processed by the dispatcher with the client's 
+// identity and address. It's meant for debugging, since it prints a lot.
+func Logging(identifier uint16, next Handler) Handler {
+	return func(client *structures.Client, packet interface{}, buffer []byte) {
+		fmt.Printf("%s (%d): %T, length %d\n", client.RemoteAddr(), 
+			client.Identity, packet, len(buffer))
+		next(client, packet, buffer)
+	}
+}
+
+// Timing returns middleware which prints handlers that take longer than the 
+// threshold to process a packet.
+func Timing(threshold time.Duration) Middleware {
+	return func(identifier uint16, next Handler) Handler {
+		return func(client *structures.Client, packet interface{}, buffer []byte) {
+			started := time.Now()
+			next(client, packet, buffer)
+			if elapsed := time.Since(started); elapsed > threshold {
+				fmt.Printf("slow handler for packet %d: %s\n", identifier, elapsed)
+			}
+		}
+	}
+}
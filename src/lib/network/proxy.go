@@ -0,0 +1,120 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PROXY protocol definitions. Load balancers and DDoS-protection proxies send a 
+// PROXY protocol header at the start of the connection with the address of the 
+// real client. Version 1 is a text header; version 2 is a binary header which 
+// starts with the signature below.
+const PROXY_TIMEOUT = 5 * time.Second
+var proxysignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrProxyLocal is returned for PROXY headers which don't carry the client's 
+// address: UNKNOWN and LOCAL headers, which proxies send for their own health 
+// checks, and unsupported address families. The connection can't be admitted 
+// without the client's address, so it must be closed.
+var ErrProxyLocal = errors.New("proxy: header has no client address")
+
+// AcceptProxy reads the PROXY protocol header from a connection if the connection
+// comes from one of the trusted proxies. The returned connection reports the real
+// client's address from RemoteAddr. Connections from other addresses are returned
+// unchanged, since their headers can't be trusted. Returns ErrProxyLocal if the 
+// header doesn't carry the client's address.
+func AcceptProxy(connection net.Conn, trusted []*net.IPNet) (net.Conn, error) {
+	if !Trusted(connection.RemoteAddr(), trusted) { return connection, nil }
+	connection.SetReadDeadline(time.Now().Add(PROXY_TIMEOUT))
+	defer connection.SetReadDeadline(time.Time {})
+	
+	// Read the start of the header and determine the version.
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(connection, header); err != nil { return nil, err }
+	var addr net.Addr
+	var err error
+	if bytes.Equal(header, proxysignature) {
+		addr, err = readProxyV2(connection)
+	} else if bytes.HasPrefix(header, []byte("PROXY ")) {
+		addr, err = readProxyV1(connection, header)
+	} else { err = errors.New("proxy: missing PROXY protocol header") }
+	if err != nil { return nil, err }
+	if addr == nil { return nil, ErrProxyLocal }
+	return &proxyconn { Conn: connection, remote: addr }, nil
+}
+
+// Trusted returns true if the address is in one of the trusted networks.
+func Trusted(addr net.Addr, trusted []*net.IPNet) bool {
+	if len(trusted) == 0 { return false }
+	ip := net.ParseIP(hostname(addr))
+	return ip != nil && containsIP(trusted, ip)
+}
+
+// ParseNetworks parses a list of CIDR blocks or single addresses from the 
+// server's configuration file, such as the list of trusted proxies.
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	return parseNetworks(list)
+}
+
+// readProxyV1 reads the rest of a text header, which is terminated by CRLF and
+// may be up to 107 bytes long. For example: "PROXY TCP4 1.2.3.4 5.6.7.8 1 2\r\n".
+// Returns a nil address for UNKNOWN connections.
+func readProxyV1(connection io.Reader, header []byte) (net.Addr, error) {
+	line := header
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 { return nil, errors.New("proxy: header too long") }
+		if _, err := io.ReadFull(connection, b); err != nil { return nil, err }
+		line = append(line, b[0])
+	}
+	
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" { return nil, nil }
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("proxy: invalid v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil { return nil, errors.New("proxy: invalid v1 address") }
+	return &net.TCPAddr { IP: ip, Port: int(port) }, nil
+}
+
+// readProxyV2 reads the rest of a binary header after the signature: the version
+// and command, the address family, the length of the addresses, then the source
+// and destination addresses and ports. Returns a nil address for LOCAL commands
+// (health checks from the proxy itself) and unsupported address families.
+func readProxyV2(connection io.Reader) (net.Addr, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(connection, header); err != nil { return nil, err }
+	if header[0] >> 4 != 2 { return nil, errors.New("proxy: invalid v2 version") }
+	body := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+	if _, err := io.ReadFull(connection, body); err != nil { return nil, err }
+	if header[0] & 0x0F == 0 { return nil, nil } // LOCAL
+	
+	switch header[1] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 { return nil, errors.New("proxy: short v2 address") }
+		return &net.TCPAddr { IP: net.IP(body[0:4]), 
+			Port: int(binary.BigEndian.Uint16(body[8:10])) }, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 { return nil, errors.New("proxy: short v2 address") }
+		return &net.TCPAddr { IP: net.IP(body[0:16]), 
+			Port: int(binary.BigEndian.Uint16(body[32:34])) }, nil
+	}
+	return nil, nil
+}
+
+// proxyconn is a connection accepted through a trusted proxy. It reports the real
+// client's address as the connection's remote address.
+type proxyconn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxyconn) RemoteAddr() net.Addr { return c.remote }
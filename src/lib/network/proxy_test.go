@@ -0,0 +1,94 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// TestProxyV1 checks text headers. The first 12 bytes are read by AcceptProxy
+// before the header's version is known, so they're passed in separately.
+func TestProxyV1(t *testing.T) {
+	tests := []struct {
+		name, header, addr string
+		fails              bool
+	}{
+		{ "tcp4", "PROXY TCP4 1.2.3.4 5.6.7.8 4000 9958\r\n", "1.2.3.4:4000", 
+			false },
+		{ "tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 4000 9958\r\n",
+			"[2001:db8::1]:4000", false },
+		{ "unknown", "PROXY UNKNOWN\r\n", "", false },
+		{ "unknown addresses", "PROXY UNKNOWN 1.2.3.4 5.6.7.8 4000 9958\r\n", "",
+			false },
+		{ "overlong", "PROXY TCP4 " + string(bytes.Repeat([]byte("1"), 120)) +
+			"\r\n", "", true },
+		{ "unterminated", "PROXY TCP4 1.2.3.4 5.6.7.8 4000 9958", "", true },
+		{ "bad protocol", "PROXY UDP4 1.2.3.4 5.6.7.8 4000 9958\r\n", "", true },
+		{ "bad address", "PROXY TCP4 1.2.3 5.6.7.8 4000 9958\r\n", "", true },
+		{ "bad port", "PROXY TCP4 1.2.3.4 5.6.7.8 70000 9958\r\n", "", true },
+		{ "missing fields", "PROXY TCP4 1.2.3.4 5.6.7.8\r\n", "", true },
+	}
+	for _, test := range tests {
+		header, rest := []byte(test.header[:12]), []byte(test.header[12:])
+		addr, err := readProxyV1(bytes.NewReader(rest), header)
+		if test.fails {
+			if err == nil { t.Errorf("%s: got %v, want an error", test.name, addr) }
+			continue
+		}
+		if err != nil { t.Errorf("%s: %v", test.name, err); continue }
+		if test.addr == "" && addr != nil {
+			t.Errorf("%s: got %v, want no address", test.name, addr)
+		} else if test.addr != "" && (addr == nil || addr.String() != test.addr) {
+			t.Errorf("%s: got %v, want %s", test.name, addr, test.addr)
+		}
+	}
+}
+
+// proxyv2 builds a binary header after the signature, with the version and
+// command, the address family, and the addresses.
+func proxyv2(command, family byte, body []byte) []byte {
+	header := []byte { 0x20 | command, family, 0, 0 }
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(body)))
+	return append(header, body...)
+}
+
+// TestProxyV2 checks binary headers. The signature is read by AcceptProxy, so
+// each header starts with the version and command.
+func TestProxyV2(t *testing.T) {
+	ipv4 := []byte { 1, 2, 3, 4, 5, 6, 7, 8, 0x0F, 0xA0, 0x26, 0xE6 }
+	ipv6 := append(append([]byte(nil), net.ParseIP("2001:db8::1")...),
+		net.ParseIP("2001:db8::2")...)
+	ipv6 = append(ipv6, 0x0F, 0xA0, 0x26, 0xE6)
+	tests := []struct {
+		name   string
+		header []byte
+		addr   string
+		fails  bool
+	}{
+		{ "ipv4", proxyv2(1, 0x11, ipv4), "1.2.3.4:4000", false },
+		{ "ipv6", proxyv2(1, 0x21, ipv6), "[2001:db8::1]:4000", false },
+		{ "local", proxyv2(0, 0x11, ipv4), "", false },
+		{ "local without addresses", proxyv2(0, 0, nil), "", false },
+		{ "unix", proxyv2(1, 0x31, make([]byte, 216)), "", false },
+		{ "short ipv4", proxyv2(1, 0x11, ipv4[:8]), "", true },
+		{ "short ipv6", proxyv2(1, 0x21, ipv6[:32]), "", true },
+		{ "short body", proxyv2(1, 0x11, ipv4)[:10], "", true },
+		{ "short header", []byte { 0x21, 0x11 }, "", true },
+		{ "bad version", append([]byte { 0x11 }, proxyv2(1, 0x11, ipv4)[1:]...),
+			"", true },
+	}
+	for _, test := range tests {
+		addr, err := readProxyV2(bytes.NewReader(test.header))
+		if test.fails {
+			if err == nil { t.Errorf("%s: got %v, want an error", test.name, addr) }
+			continue
+		}
+		if err != nil { t.Errorf("%s: %v", test.name, err); continue }
+		if test.addr == "" && addr != nil {
+			t.Errorf("%s: got %v, want no address", test.name, addr)
+		} else if test.addr != "" && (addr == nil || addr.String() != test.addr) {
+			t.Errorf("%s: got %v, want %s", test.name, addr, test.addr)
+		}
+	}
+}
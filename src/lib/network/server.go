@@ -6,39 +6,101 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"lib/capture"
+	"lib/protocol"
 	"lib/structures"
+	"lib/threadsafe"
 	"net"
+	"sync"
+	"time"
 )
 
 // Server contains function pointers to events which the server calls to process
 // client requests. These function pointers should be initialized upon creating
-// the structure. Not all events are required to be defined.
+// the structure. Not all events are required to be defined. If Timeout is set, 
+// clients which don't send anything for that duration are disconnected. If 
+// Heartbeat is set, the OnHeartbeat event is called for each client on that 
+// interval (usually to ping the client, which keeps the connection alive). If
+// Admission is set, new connections must be admitted by it to be accepted. If 
+// Flood is set, packets are checked against the flood policy before OnReceive is
+// called; OnFlood is called when a client is warned or kicked for flooding. If
+// Capture is set to a directory, each session's packets are recorded to a new 
+// capture file in that directory (see the capture package for its format). If 
+// CrashReports is set to a directory, recovered panics are reported there. 
+// Connections from Proxies must start with a PROXY protocol header, and are 
+// treated as coming from the client address in the header. If Profile is set, 
+// clients start with that protocol profile, until the client's version is known.
 type Server struct {
 	OnConnect    func(*structures.Client)
 	OnExchange   func(*structures.Client, *bytes.Buffer)
 	OnReceive    func(*structures.Client, *bytes.Buffer)
 	OnDisconnect func(*structures.Client)
+	OnHeartbeat  func(*structures.Client)
+	OnFlood      func(*structures.Client, uint16, FloodAction)
+	Timeout      time.Duration
+	Heartbeat    time.Duration
+	Admission    *Admission
+	Flood        *FloodPolicy
+	Capture      string
+	CrashReports string
+	Proxies      []*net.IPNet
+	Profile      *protocol.Profile
+	
+	listener net.Listener
+	clients  *threadsafe.SafeMap
+	sessions sync.WaitGroup
+	mutex    sync.Mutex
+	closing  bool
+	panics   uint64
 }
 
 // Listen binds the new server to a hostname and port, which is a network
 // interface and port on the local machine; defaulted to localhost. It then
 // immediately starts the server and accepts new connections from clients on a 
 // separate go routine (non-blocking). It returns true on the channel if the 
-// listen was successful and the server terminates without error.
-func (s Server) Listen(host string, ch chan bool) {
+// listen was successful and the server terminates without error, which happens 
+// once Shutdown has been called.
+func (s *Server) Listen(host string, ch chan bool) {
 
 	// Listen for incoming connections from the game client.
 	listener, err := net.Listen("tcp", host)
 	if err != nil { fmt.Println(err.Error()); ch <- false; return }
-	defer listener.Close()	
+	s.mutex.Lock()
+	if s.clients == nil { s.clients = threadsafe.NewSafeMap() }
+	s.listener = listener
+	if s.closing { listener.Close() }
+	s.mutex.Unlock()
+	defer listener.Close()
 	
 	for { // While the application is running, accept new connections.
+		if s.Admission != nil { s.Admission.Throttle() }
 		connection, err := listener.Accept()
-		if err != nil { fmt.Println(err.Error())
-		} else { go s.Accept(connection) }
+		if err != nil { 
+			if s.isClosing() { break }
+			fmt.Println(err.Error())
+			continue
+		}
+		
+		// Check the connection against the admission layer. Connections from 
+		// trusted proxies are checked once the client's real address is known.
+		proxied := Trusted(connection.RemoteAddr(), s.Proxies)
+		if !proxied && !s.admit(connection) { connection.Close(); continue }
+		
+		// Register the session before the go routine starts.
+		s.mutex.Lock()
+		if s.closing { 
+			s.mutex.Unlock()
+			if !proxied { s.release(connection) }
+			connection.Close()
+			break 
+		}
+		s.sessions.Add(1)
+		s.mutex.Unlock()
+		go s.Accept(connection) 
 	}
 	ch <- true
 }
@@ -46,37 +108,81 @@ func (s Server) Listen(host string, ch chan bool) {
 // Accept is called by the listener's go routine, created when the server accepts
 // a new connection from the remote client. It receives data from the client's
 // remote descriptor as long as the client is connected by calling into the 
-// server's receive function. 
-func (s Server) Accept(connection net.Conn) {
-	defer connection.Close()
-	client := &structures.Client { Connection: connection }
+// server's receive function. Panics from the client's events are recovered here,
+// so only the client which caused the panic is disconnected. If the connection 
+// comes from a trusted proxy, the PROXY protocol header is read first.
+func (s *Server) Accept(connection net.Conn) {
+	defer s.sessions.Done()
+	if Trusted(connection.RemoteAddr(), s.Proxies) {
+		proxied, err := AcceptProxy(connection, s.Proxies)
+		if err != nil {
+			if err != ErrProxyLocal { 
+				fmt.Printf("rejected connection from %s: %s\n", 
+					connection.RemoteAddr(), err)
+			}
+			connection.Close()
+			return
+		}
+		if !s.admit(proxied) { connection.Close(); return }
+		connection = proxied
+	}
+	client := structures.NewClient(connection, s.recovered)
+	if s.Profile != nil { client.Profile, client.Patch = s.Profile, s.Profile.Minimum }
+	defer s.recover(client)
+	defer s.release(connection)
+	defer client.Disconnect()
+	s.clients.Add(client, nil)
+	defer s.clients.Remove(client)
+	
+	// Shutdown may have started before the client was added to the clients pool,
+	// in which case it didn't disconnect the client.
+	if s.isClosing() { return }
+	if s.Capture != "" {
+		writer, err := capture.Create(s.Capture, client.RemoteAddr().String())
+		if err != nil { fmt.Println(err) } else { client.Capture = writer }
+	}
 	if s.OnConnect != nil { s.OnConnect(client) }
+	if s.OnHeartbeat != nil && s.Heartbeat > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go s.heartbeat(client, done)
+	}
 	s.Receive(client)
 }
 
 // Receive is called by the Accept function to receive data from the client. If 
-// the OnExchange event is defined, a buffer will be created for receiving data
-// for the initial key exchange; else, the event will be skipped and go straight 
-// to the receive loop. In the receive loop, packets are received by reading in 
-// the header and then reading in the body using the expected packet length. 
+// the OnExchange event is defined, the client's key exchange packet is read and
+// passed to the event first (see protocol.ReadExchange); else, the event will be
+// skipped and go straight to the receive loop. In the receive loop, packets are 
+// received by reading in the header and then reading in the body using the 
+// expected packet length, followed by the client's seal if the client's profile 
+// is sealed. 
 // Since golang buffers data automatically, this shouldn't be any more costly 
 // than handling packet splitting and the client's packet fragmentation using 
 // pointer arithmetic and buffer persistence. 
-func (s Server) Receive(client *structures.Client) {
+func (s *Server) Receive(client *structures.Client) {
 	defer s.Disconnect(client)
 	if s.OnExchange != nil {
 		
-		// Create the buffer and receive for an unknown length.
-		buffer := make([]byte, 4096)
-		length, err := client.Connection.Read(buffer)
-		if err != nil || length == 0 { return }
-		client.Cipher.Decrypt(buffer)
-		packet := bytes.NewBuffer(buffer)
-		s.OnExchange(client, packet)
+		// Read the exchange packet, which has its own length prefix.
+		s.deadline(client)
+		packet, err := protocol.ReadExchange(client.Connection, client.Cipher)
+		if err != nil { 
+			if err != io.EOF { 
+				fmt.Printf("key exchange failed for %s: %s\n", 
+					client.RemoteAddr(), err) 
+			}
+			return 
+		}
+		s.OnExchange(client, bytes.NewBuffer(packet))
 	}
 	if s.OnReceive == nil { return }
+	var limiter *FloodLimiter
+	if s.Flood != nil { limiter = s.Flood.NewLimiter() }
 	for {
-		// The first two bytes contains the expected length.
+		// The first two bytes contains the expected length. The read deadline is
+		// extended with every packet received from the client.
+		s.deadline(client)
 		buffer := make([]byte, 2)
 		length, err := io.ReadFull(client.Connection, buffer)
 		if err != nil || length != 2 { return }
@@ -97,8 +203,20 @@ func (s Server) Receive(client *structures.Client) {
 		// Combine the buffers and process.
 		binary.LittleEndian.PutUint16(packet[0:2], uint16(length))
 		client.Cipher.Decrypt(packet[2:length])
-		p := bytes.NewBuffer(packet)
-		s.OnReceive(client, p)
+		if client.Profile != nil && client.Profile.Sealed && !s.seal(client) { 
+			return 
+		}
+		if client.Capture != nil { client.Capture.Record(capture.INBOUND, packet) }
+		
+		// Check the packet against the flood policy.
+		if limiter != nil {
+			identifier := binary.LittleEndian.Uint16(packet[2:4])
+			if action := limiter.Check(identifier); action != FLOOD_ALLOW {
+				if s.flood(client, identifier, action) { return }
+				continue
+			}
+		}
+		s.process(client, packet)
 	}
 }
 
@@ -106,6 +224,115 @@ func (s Server) Receive(client *structures.Client) {
 // occurs or the client has disconnected from the server. This calls the 
 // disconnect event for processing client features upon disconnect (such as 
 // discontinuing trade transactions, removing the character from the map, etc).
-func (s Server) Disconnect(client *structures.Client) {
+func (s *Server) Disconnect(client *structures.Client) {
 	if s.OnDisconnect != nil { s.OnDisconnect(client) }
 }
+
+// Shutdown gracefully stops the server. It stops accepting new connections, 
+// closes every connected client's socket, and then waits for each client's 
+// receive loop to return, which calls the OnDisconnect event for that client. 
+// If the context expires before all clients have disconnected, the context's 
+// error is returned and the remaining sessions are abandoned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closing = true
+	if s.listener != nil { s.listener.Close() }
+	clients := s.clients
+	s.mutex.Unlock()
+	
+	// Close client connections, interrupting their blocking reads.
+	if clients != nil {
+		for _, client := range clients.Keys() {
+			client.(*structures.Client).Disconnect()
+		}
+	}
+	
+	// Wait for the disconnect events to finish processing.
+	done := make(chan struct{})
+	go func() { s.sessions.Wait(); close(done) }()
+	select {
+	case <-done: return nil
+	case <-ctx.Done(): return ctx.Err()
+	}
+}
+
+// flood handles a packet dropped by the flood policy. Warnings and kicks are 
+// logged and passed to the OnFlood event; kicked clients are also recorded. 
+// Returns true if the client should be disconnected.
+func (s *Server) flood(client *structures.Client, identifier uint16, 
+	action FloodAction) bool {
+	
+	switch action {
+	case FLOOD_WARN:
+		fmt.Printf("flood warning for %s: packet %d\n", 
+			client.RemoteAddr(), identifier)
+	case FLOOD_KICK:
+		fmt.Printf("flood kick for %s: packet %d\n", 
+			client.RemoteAddr(), identifier)
+		s.Flood.Record(client, identifier)
+	default: 
+		return false
+	}
+	if s.OnFlood != nil { s.OnFlood(client, identifier, action) }
+	return action == FLOOD_KICK
+}
+
+// admit checks a new connection against the admission layer. Rejections are 
+// logged with the reason the connection was rejected.
+func (s *Server) admit(connection net.Conn) bool {
+	if s.Admission == nil { return true }
+	if err := s.Admission.Admit(connection.RemoteAddr()); err != nil {
+		fmt.Printf("rejected connection from %s: %s\n", 
+			connection.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+// release returns an admitted connection's slot to the admission layer.
+func (s *Server) release(connection net.Conn) {
+	if s.Admission != nil { s.Admission.Release(connection.RemoteAddr()) }
+}
+
+// seal reads and checks the client's seal, which follows each packet for sealed
+// profiles. Returns false if the seal couldn't be read or is invalid.
+func (s *Server) seal(client *structures.Client) bool {
+	seal := make([]byte, len(protocol.SEAL_CLIENT))
+	if _, err := io.ReadFull(client.Connection, seal); err != nil { return false }
+	client.Cipher.Decrypt(seal)
+	if string(seal) != protocol.SEAL_CLIENT {
+		fmt.Println("invalid packet seal")
+		return false
+	}
+	return true
+}
+
+// deadline extends the client's read deadline by the server's timeout. Reads 
+// which pass the deadline fail, ending the client's receive loop.
+func (s *Server) deadline(client *structures.Client) {
+	if s.Timeout > 0 { 
+		client.Connection.SetReadDeadline(time.Now().Add(s.Timeout)) 
+	}
+}
+
+// heartbeat calls the OnHeartbeat event for a client on the server's heartbeat
+// interval, until the done channel is closed by the client's Accept function.
+// Panics from the event disconnect the client, like panics from its packets.
+func (s *Server) heartbeat(client *structures.Client, done chan struct{}) {
+	defer s.recover(client)
+	ticker := time.NewTicker(s.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C: s.OnHeartbeat(client)
+		case <-done: return
+		}
+	}
+}
+
+// isClosing returns true if Shutdown has been called on the server.
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closing
+}
@@ -0,0 +1,247 @@
+package packets
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the codec, wrapped in a FieldError naming the field which
+// couldn't be encoded or decoded.
+var (
+	ErrUnexpectedEnd = errors.New("unexpected end of data")
+	ErrCountExceeded = errors.New("count exceeds the remaining data")
+	ErrTooLong       = errors.New("value too long for its length prefix")
+)
+
+// FieldError is returned by Read and Write when a field can't be decoded or
+// encoded. Field names the structure and path of the field, including indexes for
+// fields in collections (for example, "MsgTalk.Strings[2]").
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string { return "packets: " + e.Field + ": " + e.Err.Error() }
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// MAX_DEPTH is the maximum nesting of collections in a packet structure.
+const MAX_DEPTH = 8
+
+// cursor tracks the first error and the current collection indexes while encoding
+// or decoding a packet structure. Field names passed to the codec use "[]" for
+// each collection index, which are only filled in from indexes if the field fails,
+// so naming fields costs nothing on success. Patch is the client patch being 
+// encoded or decoded by the reflection codec, for since tags.
+type cursor struct {
+	err     error
+	indexes [MAX_DEPTH]int
+	patch   uint16
+}
+
+// fail records the first error on the cursor for the named field.
+func (c *cursor) fail(field string, err error) {
+	if c.err != nil { return }
+	var name strings.Builder
+	for depth := 0; depth < MAX_DEPTH; depth++ {
+		i := strings.Index(field, "[]")
+		if i < 0 { break }
+		name.WriteString(field[:i + 1])
+		name.WriteString(strconv.Itoa(c.indexes[depth]))
+		field = field[i + 1:]
+	}
+	name.WriteString(field)
+	c.err = &FieldError { name.String(), err }
+}
+
+// encoder appends fields to a binary packet structure. Values are encoded using
+// NetDragon's byte order (little endian), independent of the host's byte order.
+// It's used by both the generated MarshalBinary methods and the reflection codec.
+type encoder struct {
+	cursor
+	data []byte
+}
+
+func (e *encoder) uint8(v uint8)   { e.data = append(e.data, v) }
+func (e *encoder) uint16(v uint16) { e.data = binary.LittleEndian.AppendUint16(e.data, v) }
+func (e *encoder) uint32(v uint32) { e.data = binary.LittleEndian.AppendUint32(e.data, v) }
+func (e *encoder) uint64(v uint64) { e.data = binary.LittleEndian.AppendUint64(e.data, v) }
+
+func (e *encoder) bool(v bool) {
+	if v { e.data = append(e.data, 1) } else { e.data = append(e.data, 0) }
+}
+
+// zero appends padding for a blank field or skip tag.
+func (e *encoder) zero(length int) {
+	for ; length > 0; length-- { e.data = append(e.data, 0) }
+}
+
+// fixed appends a string padded or truncated to a fixed length.
+func (e *encoder) fixed(v string, length int) {
+	if len(v) > length { v = v[:length] }
+	e.data = append(e.data, v...)
+	e.zero(length - len(v))
+}
+
+// dynamic appends a string prefixed with its length as a single byte.
+func (e *encoder) dynamic(field string, v string) {
+	if len(v) > 0xFF { e.fail(field, ErrTooLong); return }
+	e.data = append(e.data, byte(len(v)))
+	e.data = append(e.data, v...)
+}
+
+// count appends the number of elements in a collection using an integer of the
+// given width in bytes.
+func (e *encoder) count(field string, width, count int) {
+	if count >= 1 << (8 * uint(width)) { e.fail(field, ErrTooLong); return }
+	e.prefix(width, uint32(count))
+}
+
+// begin reserves a length prefix of the given width for a sub-record, and returns
+// the offset of the prefix to pass to end once the record has been encoded.
+func (e *encoder) begin(width int) int {
+	offset := len(e.data)
+	e.zero(width)
+	return offset
+}
+
+// end writes the length of a sub-record into the prefix reserved by begin.
+func (e *encoder) end(field string, width, offset int) {
+	length := len(e.data) - offset - width
+	if length >= 1 << (8 * uint(width)) { e.fail(field, ErrTooLong); return }
+	b := e.data[offset:offset + width]
+	switch width {
+	case 1: b[0] = byte(length)
+	case 2: binary.LittleEndian.PutUint16(b, uint16(length))
+	case 4: binary.LittleEndian.PutUint32(b, uint32(length))
+	}
+}
+
+// prefix appends an unsigned integer of the given width in bytes.
+func (e *encoder) prefix(width int, v uint32) {
+	switch width {
+	case 1: e.uint8(uint8(v))
+	case 2: e.uint16(uint16(v))
+	case 4: e.uint32(v)
+	}
+}
+
+// decoder reads fields from a binary packet structure. Every read is bounds
+// checked: once a read runs past the end of the data, the decoder records an error
+// naming the field and all following reads return zero values. It's used by both
+// the generated UnmarshalBinary methods and the reflection codec.
+type decoder struct {
+	cursor
+	data []byte
+}
+
+// next returns the next length bytes of the data, or nil if there aren't enough
+// bytes remaining.
+func (d *decoder) next(field string, length int) []byte {
+	if d.err != nil { return nil }
+	if length > len(d.data) {
+		d.fail(field, ErrUnexpectedEnd)
+		return nil
+	}
+	b := d.data[:length]
+	d.data = d.data[length:]
+	return b
+}
+
+func (d *decoder) uint8(field string) uint8 {
+	if b := d.next(field, 1); b != nil { return b[0] }
+	return 0
+}
+
+func (d *decoder) uint16(field string) uint16 {
+	if b := d.next(field, 2); b != nil { return binary.LittleEndian.Uint16(b) }
+	return 0
+}
+
+func (d *decoder) uint32(field string) uint32 {
+	if b := d.next(field, 4); b != nil { return binary.LittleEndian.Uint32(b) }
+	return 0
+}
+
+func (d *decoder) uint64(field string) uint64 {
+	if b := d.next(field, 8); b != nil { return binary.LittleEndian.Uint64(b) }
+	return 0
+}
+
+func (d *decoder) bool(field string) bool { return d.uint8(field) != 0 }
+
+// skip reads past the padding of a blank field or skip tag.
+func (d *decoder) skip(field string, length int) { d.next(field, length) }
+
+// fixed reads a string of a fixed length, trimming its null padding.
+func (d *decoder) fixed(field string, length int) string {
+	return strings.TrimRight(string(d.next(field, length)), "\x00")
+}
+
+// dynamic reads a string prefixed with its length as a single byte.
+func (d *decoder) dynamic(field string) string {
+	length := int(d.uint8(field))
+	return strings.TrimRight(string(d.next(field, length)), "\x00")
+}
+
+// count reads the number of elements in a collection using an integer of the
+// given width in bytes. Since each element takes at least minimum bytes, counts
+// which couldn't fit in the remaining data are rejected before anything is
+// allocated for them.
+func (d *decoder) count(field string, width, minimum int) int {
+	count := int(d.prefix(field, width))
+	if minimum < 1 { minimum = 1 }
+	if d.err == nil && count > len(d.data) / minimum {
+		d.fail(field, ErrCountExceeded)
+	}
+	if d.err != nil { return 0 }
+	return count
+}
+
+// begin reads the length prefix of a sub-record and limits the decoder to the
+// record's data. The remaining data is returned to pass to end once the record
+// has been decoded. Bytes at the end of the record which weren't decoded are
+// skipped, so records can be extended by newer clients.
+func (d *decoder) begin(field string, width int) []byte {
+	length := int(d.prefix(field, width))
+	if d.err == nil && length > len(d.data) { d.fail(field, ErrUnexpectedEnd) }
+	if d.err != nil { return nil }
+	remaining := d.data[length:]
+	d.data = d.data[:length]
+	return remaining
+}
+
+// end restores the data remaining after a sub-record.
+func (d *decoder) end(remaining []byte) { d.data = remaining }
+
+// prefix reads an unsigned integer of the given width in bytes.
+func (d *decoder) prefix(field string, width int) uint32 {
+	switch width {
+	case 1: return uint32(d.uint8(field))
+	case 2: return uint32(d.uint16(field))
+	default: return d.uint32(field)
+	}
+}
+
+// since returns true if a field is present in the layout of a patch. Fields tagged
+// since:"n" are only present for patches n and higher.
+func since(tag reflect.StructTag, patch uint16) (bool, error) {
+	value := tag.Get("since")
+	if value == "" { return true, nil }
+	minimum, err := strconv.ParseUint(value, 10, 16)
+	if err != nil { return false, err }
+	return patch >= uint16(minimum), nil
+}
+
+// width returns the width in bytes of a count or prefix tag, which is one of
+// "uint8", "uint16", or "uint32". An empty tag defaults to a single byte.
+func width(tag string) (int, error) {
+	switch tag {
+	case "", "uint8", "byte": return 1, nil
+	case "uint16": return 2, nil
+	case "uint32": return 4, nil
+	}
+	return 0, errors.New("invalid width " + tag)
+}
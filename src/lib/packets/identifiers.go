@@ -4,8 +4,24 @@
 // efficiency. Applications which require high-performance serialization, 
 // especially for large data structures, should look at more advanced solutions 
 // such as protocol buffers. 
+//
+// Fields are encoded in declaration order using NetDragon's byte order. Strings 
+// are prefixed with a one byte length unless tagged with a fixed length, and 
+// slices are prefixed with a one byte count. Tags change the layout of a field:
+//
+//	len:"16"        string (or list of strings) of a fixed, null padded length
+//	count:"uint16"  width of a slice's count prefix: uint8, uint16, or uint32
+//	skip:"2"        bytes of padding before the field
+//	prefix:"uint16" structure encoded as a sub-record, prefixed by its length
+//	since:"5017"    field only present in the layout of patches 5017 and higher
+//
+// Packet structures which embed PacketHeader have MarshalBinary and 
+// UnmarshalBinary methods generated by packetgen, which Read and Write use in 
+// place of reflection. Run go generate after changing a packet structure.
 package packets
 
+//go:generate go run packetgen/main -output marshal_gen.go
+
 // PacketHeader is the binary header structure for NetDragon Websoft packets. If
 // your client crashes or disconnects, it's likely due to an invalid length sent
 // in this header.
@@ -15,12 +31,14 @@ type PacketHeader struct {
 
 // Identifiers for packet structures.
 const (
-	MSGREGISTER  = 1001
-	MSGTALK      = 1004
-	MSGUSERINFO	 = 1006
-	MSGITEM		 = 1009
-	MSGACTION	 = 1010
-	MSGACCOUNT   = 1051
-	MSGCONNECT   = 1052
-	MSGCONNECTEX = 1055
+	MSGREGISTER    = 1001
+	MSGTALK        = 1004
+	MSGUSERINFO    = 1006
+	MSGITEM        = 1009
+	MSGACTION      = 1010
+	MSGNAME        = 1015
+	MSGACCOUNT     = 1051
+	MSGCONNECT     = 1052
+	MSGCONNECTEX   = 1055
+	MSGENCRYPTCODE = 1059
 )
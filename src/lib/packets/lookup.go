@@ -0,0 +1,21 @@
+package packets
+
+// Lookup returns a new packet structure for a packet identifier, which can be 
+// passed to Read to decode the packet. It returns nil if the identifier isn't 
+// known. It's used by tools which decode packets without a dispatcher, such as 
+// the dissect command.
+func Lookup(identifier uint16) interface{} {
+	switch identifier {
+	case MSGREGISTER:    return new(MsgRegister)
+	case MSGTALK:        return new(MsgTalk)
+	case MSGUSERINFO:    return new(MsgUserInfo)
+	case MSGITEM:        return new(MsgItem)
+	case MSGACTION:      return new(MsgAction)
+	case MSGNAME:        return new(MsgName)
+	case MSGACCOUNT:     return new(MsgAccount)
+	case MSGCONNECT:     return new(MsgConnect)
+	case MSGCONNECTEX:   return new(MsgConnectEx)
+	case MSGENCRYPTCODE: return new(MsgEncryptCode)
+	}
+	return nil
+}
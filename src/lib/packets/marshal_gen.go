@@ -0,0 +1,429 @@
+// Code generated by packetgen; DO NOT EDIT.
+
+package packets
+
+// MarshalBinary encodes MsgAccount using the oldest patch's layout.
+func (p *MsgAccount) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgAccount using the oldest patch's layout.
+func (p *MsgAccount) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgAccount using a client patch's layout.
+func (p *MsgAccount) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 52)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.fixed(p.Account, 16)
+	for i1 := range p.Password {
+		e.indexes[0] = i1
+		e.uint8(p.Password[i1])
+	}
+	e.fixed(p.Server, 16)
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgAccount using a client patch's layout.
+func (p *MsgAccount) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgAccount.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgAccount.PacketHeader.Identifier")
+	p.Account = d.fixed("MsgAccount.Account", 16)
+	for i1 := range p.Password {
+		d.indexes[0] = i1
+		p.Password[i1] = d.uint8("MsgAccount.Password[]")
+	}
+	p.Server = d.fixed("MsgAccount.Server", 16)
+	return d.err
+}
+
+// MarshalBinary encodes MsgAction using the oldest patch's layout.
+func (p *MsgAction) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgAction using the oldest patch's layout.
+func (p *MsgAction) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgAction using a client patch's layout.
+func (p *MsgAction) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 24)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Timestamp)
+	e.uint32(p.Identity)
+	e.uint32(p.Data)
+	e.uint16(p.X)
+	e.uint16(p.Y)
+	e.uint16(p.Direction)
+	e.uint16(uint16(p.Action))
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgAction using a client patch's layout.
+func (p *MsgAction) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgAction.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgAction.PacketHeader.Identifier")
+	p.Timestamp = d.uint32("MsgAction.Timestamp")
+	p.Identity = d.uint32("MsgAction.Identity")
+	p.Data = d.uint32("MsgAction.Data")
+	p.X = d.uint16("MsgAction.X")
+	p.Y = d.uint16("MsgAction.Y")
+	p.Direction = d.uint16("MsgAction.Direction")
+	p.Action = MsgActionType(d.uint16("MsgAction.Action"))
+	return d.err
+}
+
+// MarshalBinary encodes MsgConnect using the oldest patch's layout.
+func (p *MsgConnect) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgConnect using the oldest patch's layout.
+func (p *MsgConnect) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgConnect using a client patch's layout.
+func (p *MsgConnect) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 28)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Identity)
+	e.uint32(p.Token)
+	e.fixed(p.Version, 4)
+	e.fixed(p.Language, 12)
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgConnect using a client patch's layout.
+func (p *MsgConnect) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgConnect.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgConnect.PacketHeader.Identifier")
+	p.Identity = d.uint32("MsgConnect.Identity")
+	p.Token = d.uint32("MsgConnect.Token")
+	p.Version = d.fixed("MsgConnect.Version", 4)
+	p.Language = d.fixed("MsgConnect.Language", 12)
+	return d.err
+}
+
+// MarshalBinary encodes MsgConnectEx using the oldest patch's layout.
+func (p *MsgConnectEx) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgConnectEx using the oldest patch's layout.
+func (p *MsgConnectEx) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgConnectEx using a client patch's layout.
+func (p *MsgConnectEx) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 32)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Identity)
+	e.uint32(p.Token)
+	for i1 := range p.Address {
+		e.indexes[0] = i1
+		e.uint8(p.Address[i1])
+	}
+	e.uint32(p.Port)
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgConnectEx using a client patch's layout.
+func (p *MsgConnectEx) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgConnectEx.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgConnectEx.PacketHeader.Identifier")
+	p.Identity = d.uint32("MsgConnectEx.Identity")
+	p.Token = d.uint32("MsgConnectEx.Token")
+	for i1 := range p.Address {
+		d.indexes[0] = i1
+		p.Address[i1] = d.uint8("MsgConnectEx.Address[]")
+	}
+	p.Port = d.uint32("MsgConnectEx.Port")
+	return d.err
+}
+
+// MarshalBinary encodes MsgEncryptCode using the oldest patch's layout.
+func (p *MsgEncryptCode) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgEncryptCode using the oldest patch's layout.
+func (p *MsgEncryptCode) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgEncryptCode using a client patch's layout.
+func (p *MsgEncryptCode) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 8)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Seed)
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgEncryptCode using a client patch's layout.
+func (p *MsgEncryptCode) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgEncryptCode.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgEncryptCode.PacketHeader.Identifier")
+	p.Seed = d.uint32("MsgEncryptCode.Seed")
+	return d.err
+}
+
+// MarshalBinary encodes MsgItem using the oldest patch's layout.
+func (p *MsgItem) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgItem using the oldest patch's layout.
+func (p *MsgItem) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgItem using a client patch's layout.
+func (p *MsgItem) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 20)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Identity)
+	e.uint32(p.Argument)
+	e.uint32(uint32(p.Action))
+	e.uint32(p.Timestamp)
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgItem using a client patch's layout.
+func (p *MsgItem) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgItem.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgItem.PacketHeader.Identifier")
+	p.Identity = d.uint32("MsgItem.Identity")
+	p.Argument = d.uint32("MsgItem.Argument")
+	p.Action = MsgItemType(d.uint32("MsgItem.Action"))
+	p.Timestamp = d.uint32("MsgItem.Timestamp")
+	return d.err
+}
+
+// MarshalBinary encodes MsgName using the oldest patch's layout.
+func (p *MsgName) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgName using the oldest patch's layout.
+func (p *MsgName) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgName using a client patch's layout.
+func (p *MsgName) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 10)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Identity)
+	e.uint8(p.Action)
+	e.count("MsgName.Strings", 1, len(p.Strings))
+	for i1 := range p.Strings {
+		e.indexes[0] = i1
+		e.dynamic("MsgName.Strings[]", p.Strings[i1])
+	}
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgName using a client patch's layout.
+func (p *MsgName) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgName.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgName.PacketHeader.Identifier")
+	p.Identity = d.uint32("MsgName.Identity")
+	p.Action = d.uint8("MsgName.Action")
+	p.Strings = make([]string, d.count("MsgName.Strings", 1, 1))
+	for i1 := range p.Strings {
+		d.indexes[0] = i1
+		p.Strings[i1] = d.dynamic("MsgName.Strings[]")
+	}
+	return d.err
+}
+
+// MarshalBinary encodes MsgRegister using the oldest patch's layout.
+func (p *MsgRegister) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgRegister using the oldest patch's layout.
+func (p *MsgRegister) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgRegister using a client patch's layout.
+func (p *MsgRegister) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 60)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.fixed(p.Account, 16)
+	e.fixed(p.Name, 16)
+	e.fixed(p.Password, 16)
+	e.uint16(p.Model)
+	e.uint16(p.Class)
+	e.uint32(p.Identity)
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgRegister using a client patch's layout.
+func (p *MsgRegister) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgRegister.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgRegister.PacketHeader.Identifier")
+	p.Account = d.fixed("MsgRegister.Account", 16)
+	p.Name = d.fixed("MsgRegister.Name", 16)
+	p.Password = d.fixed("MsgRegister.Password", 16)
+	p.Model = d.uint16("MsgRegister.Model")
+	p.Class = d.uint16("MsgRegister.Class")
+	p.Identity = d.uint32("MsgRegister.Identity")
+	return d.err
+}
+
+// MarshalBinary encodes MsgTalk using the oldest patch's layout.
+func (p *MsgTalk) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgTalk using the oldest patch's layout.
+func (p *MsgTalk) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgTalk using a client patch's layout.
+func (p *MsgTalk) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 17)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Hue)
+	e.uint16(p.Tone)
+	e.uint16(p.Style)
+	e.uint32(p.Identity)
+	if patch >= 5018 {
+		e.uint32(p.Recipient)
+	}
+	if patch >= 5018 {
+		e.uint32(p.Sender)
+	}
+	e.count("MsgTalk.Strings", 1, len(p.Strings))
+	for i1 := range p.Strings {
+		e.indexes[0] = i1
+		e.dynamic("MsgTalk.Strings[]", p.Strings[i1])
+	}
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgTalk using a client patch's layout.
+func (p *MsgTalk) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgTalk.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgTalk.PacketHeader.Identifier")
+	p.Hue = d.uint32("MsgTalk.Hue")
+	p.Tone = d.uint16("MsgTalk.Tone")
+	p.Style = d.uint16("MsgTalk.Style")
+	p.Identity = d.uint32("MsgTalk.Identity")
+	if patch >= 5018 {
+		p.Recipient = d.uint32("MsgTalk.Recipient")
+	}
+	if patch >= 5018 {
+		p.Sender = d.uint32("MsgTalk.Sender")
+	}
+	p.Strings = make([]string, d.count("MsgTalk.Strings", 1, 1))
+	for i1 := range p.Strings {
+		d.indexes[0] = i1
+		p.Strings[i1] = d.dynamic("MsgTalk.Strings[]")
+	}
+	return d.err
+}
+
+// MarshalBinary encodes MsgUserInfo using the oldest patch's layout.
+func (p *MsgUserInfo) MarshalBinary() ([]byte, error) {
+	return p.MarshalPatch(0)
+}
+
+// UnmarshalBinary decodes MsgUserInfo using the oldest patch's layout.
+func (p *MsgUserInfo) UnmarshalBinary(data []byte) error {
+	return p.UnmarshalPatch(data, 0)
+}
+
+// MarshalPatch encodes MsgUserInfo using a client patch's layout.
+func (p *MsgUserInfo) MarshalPatch(patch uint16) ([]byte, error) {
+	e := encoder{data: make([]byte, 0, 62)}
+	e.uint16(p.PacketHeader.Length)
+	e.uint16(p.PacketHeader.Identifier)
+	e.uint32(p.Identity)
+	e.uint32(p.Mesh)
+	e.uint16(p.Hairstyle)
+	e.zero(2)
+	e.uint32(p.Silver)
+	e.uint64(p.Experience)
+	e.zero(8)
+	e.zero(4)
+	e.uint16(p.Strength)
+	e.uint16(p.Agility)
+	e.uint16(p.Vitality)
+	e.uint16(p.Spirit)
+	e.uint16(p.Attributes)
+	e.uint16(p.Health)
+	e.uint16(p.Mana)
+	e.uint16(p.PkPoints)
+	e.uint8(p.Level)
+	e.uint8(p.Class)
+	e.bool(p.Autoallot)
+	e.uint8(p.Rebirths)
+	e.bool(p.ShowName)
+	e.count("MsgUserInfo.Strings", 1, len(p.Strings))
+	for i1 := range p.Strings {
+		e.indexes[0] = i1
+		e.dynamic("MsgUserInfo.Strings[]", p.Strings[i1])
+	}
+	return e.data, e.err
+}
+
+// UnmarshalPatch decodes MsgUserInfo using a client patch's layout.
+func (p *MsgUserInfo) UnmarshalPatch(data []byte, patch uint16) error {
+	d := decoder{data: data}
+	p.PacketHeader.Length = d.uint16("MsgUserInfo.PacketHeader.Length")
+	p.PacketHeader.Identifier = d.uint16("MsgUserInfo.PacketHeader.Identifier")
+	p.Identity = d.uint32("MsgUserInfo.Identity")
+	p.Mesh = d.uint32("MsgUserInfo.Mesh")
+	p.Hairstyle = d.uint16("MsgUserInfo.Hairstyle")
+	d.skip("MsgUserInfo._", 2)
+	p.Silver = d.uint32("MsgUserInfo.Silver")
+	p.Experience = d.uint64("MsgUserInfo.Experience")
+	d.skip("MsgUserInfo._", 8)
+	d.skip("MsgUserInfo._", 4)
+	p.Strength = d.uint16("MsgUserInfo.Strength")
+	p.Agility = d.uint16("MsgUserInfo.Agility")
+	p.Vitality = d.uint16("MsgUserInfo.Vitality")
+	p.Spirit = d.uint16("MsgUserInfo.Spirit")
+	p.Attributes = d.uint16("MsgUserInfo.Attributes")
+	p.Health = d.uint16("MsgUserInfo.Health")
+	p.Mana = d.uint16("MsgUserInfo.Mana")
+	p.PkPoints = d.uint16("MsgUserInfo.PkPoints")
+	p.Level = d.uint8("MsgUserInfo.Level")
+	p.Class = d.uint8("MsgUserInfo.Class")
+	p.Autoallot = d.bool("MsgUserInfo.Autoallot")
+	p.Rebirths = d.uint8("MsgUserInfo.Rebirths")
+	p.ShowName = d.bool("MsgUserInfo.ShowName")
+	p.Strings = make([]string, d.count("MsgUserInfo.Strings", 1, 1))
+	for i1 := range p.Strings {
+		d.indexes[0] = i1
+		p.Strings[i1] = d.dynamic("MsgUserInfo.Strings[]")
+	}
+	return d.err
+}
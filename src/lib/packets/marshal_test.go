@@ -0,0 +1,165 @@
+package packets
+
+import (
+	"bytes"
+	"testing"
+)
+
+// userinfo returns a populated MsgUserInfo for comparing codecs.
+func userinfo() *MsgUserInfo {
+	p := NewMsgUserInfo()
+	p.Identity, p.Mesh, p.Hairstyle, p.Silver = 1000001, 1003, 410, 5000
+	p.Experience, p.Level, p.Class, p.Autoallot = 123456, 130, 15, true
+	p.Strength, p.Agility, p.Vitality, p.Spirit = 20, 40, 30, 0
+	p.Attributes, p.Health, p.Mana = 5, 1200, 300
+	p.Strings = []string { "Spirited", "Spouse" }
+	return p
+}
+
+// talk returns a populated MsgTalk for comparing codecs.
+func talk() *MsgTalk {
+	return NewMsgTalk(1000001, "SYSTEM", "ALLUSERS", "Welcome to the server!", 
+		MSGTALK_TALK)
+}
+
+// TestMarshalMatchesReflection verifies that the generated methods encode and 
+// decode the same bytes as the reflection codec.
+func TestMarshalMatchesReflection(t *testing.T) {
+	for _, packet := range []interface{} { userinfo(), talk() } {
+		generated := bytes.NewBuffer(nil)
+		if err := Write(generated, packet); err != nil { t.Fatal(err) }
+		reflected, err := writereflect(packet, 0)
+		if err != nil { t.Fatal(err) }
+		if !bytes.Equal(generated.Bytes(), reflected) {
+			t.Fatalf("%T: generated % x, reflected % x", packet, 
+				generated.Bytes(), reflected)
+		}
+	}
+	
+	p := userinfo()
+	b, _ := writereflect(p, 0)
+	generated, reflected := new(MsgUserInfo), new(MsgUserInfo)
+	if err := Read(bytes.NewBuffer(b), generated); err != nil { t.Fatal(err) }
+	if err := readreflect(b, reflected, 0); err != nil { t.Fatal(err) }
+	if generated.Identity != reflected.Identity || generated.Level != p.Level ||
+		len(generated.Strings) != 2 || generated.Strings[1] != reflected.Strings[1] {
+		t.Fatalf("generated %+v, reflected %+v", generated, reflected)
+	}
+}
+
+// layout exercises the layouts which aren't used by the current packets: string
+// lists with uint16 counts, arrays of nested structures, skip padding, and 
+// length-prefixed sub-records.
+type layout struct {
+	PacketHeader
+	Names   []string `count:"uint16"`
+	Slots   [2]struct { Identity uint32; Names []string `len:"4"` }
+	Padded  uint16   `skip:"3"`
+	Record  struct { Level byte; Title string } `prefix:"uint16"`
+	Trailer uint32
+}
+
+// TestLayouts verifies the reflection codec's tagged layouts and that errors name
+// the failing field.
+func TestLayouts(t *testing.T) {
+	p := new(layout)
+	p.Names = []string { "a", "bc" }
+	p.Slots[1].Identity = 7
+	p.Slots[1].Names = []string { "abcdef" }
+	p.Padded, p.Record.Level, p.Record.Title, p.Trailer = 9, 3, "x", 0xAABBCCDD
+	b, err := writereflect(p, 0)
+	if err != nil { t.Fatal(err) }
+	
+	expected := []byte {
+		0, 0, 0, 0, 2, 0, 1, 'a', 2, 'b', 'c', 
+		0, 0, 0, 0, 0, 7, 0, 0, 0, 1, 'a', 'b', 'c', 'd',
+		0, 0, 0, 9, 0, 3, 0, 3, 1, 'x', 0xDD, 0xCC, 0xBB, 0xAA }
+	if !bytes.Equal(b, expected) { t.Fatalf("encoded % x", b) }
+	
+	decoded := new(layout)
+	if err := readreflect(b, decoded, 0); err != nil { t.Fatal(err) }
+	if decoded.Names[1] != "bc" || decoded.Slots[1].Names[0] != "abcd" || 
+		decoded.Padded != 9 || decoded.Record.Title != "x" || 
+		decoded.Trailer != 0xAABBCCDD {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	
+	err = readreflect(b[:10], new(layout), 0)
+	if err == nil || err.Error() != "packets: layout.Names[1]: " + 
+		"unexpected end of data" {
+		t.Fatalf("error %v", err)
+	}
+	b[4], b[5] = 0xFF, 0xFF
+	if err := readreflect(b, new(layout), 0); err == nil || 
+		err.Error() != "packets: layout.Names: count exceeds the remaining data" {
+		t.Fatalf("error %v", err)
+	}
+	
+	talk := talk()
+	encoded, _ := talk.MarshalBinary()
+	if err := talk.UnmarshalBinary(encoded[:len(encoded) - 2]); err == nil ||
+		err.Error() != "packets: MsgTalk.Strings[3]: unexpected end of data" {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func benchmarkWrite(b *testing.B, packet interface{}, 
+	write func(*bytes.Buffer, interface{}) error) {
+	
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buffer.Reset()
+		if err := write(buffer, packet); err != nil { b.Fatal(err) }
+	}
+}
+
+func benchmarkRead(b *testing.B, packet, into interface{}, 
+	read func(*bytes.Buffer, interface{}) error) {
+	
+	encoded, _ := writereflect(packet, 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := read(bytes.NewBuffer(encoded), into); err != nil { 
+			b.Fatal(err) 
+		}
+	}
+}
+
+func generatedWrite(w *bytes.Buffer, p interface{}) error { return Write(w, p) }
+func generatedRead(r *bytes.Buffer, p interface{}) error  { return Read(r, p) }
+
+func reflectedWrite(w *bytes.Buffer, p interface{}) error {
+	b, err := writereflect(p, 0)
+	w.Write(b)
+	return err
+}
+
+func reflectedRead(r *bytes.Buffer, p interface{}) error { 
+	return readreflect(r.Next(r.Len()), p, 0) 
+}
+
+func BenchmarkWriteMsgUserInfoGenerated(b *testing.B) { 
+	benchmarkWrite(b, userinfo(), generatedWrite) 
+}
+func BenchmarkWriteMsgUserInfoReflection(b *testing.B) { 
+	benchmarkWrite(b, userinfo(), reflectedWrite) 
+}
+func BenchmarkWriteMsgTalkGenerated(b *testing.B) { 
+	benchmarkWrite(b, talk(), generatedWrite) 
+}
+func BenchmarkWriteMsgTalkReflection(b *testing.B) { 
+	benchmarkWrite(b, talk(), reflectedWrite) 
+}
+func BenchmarkReadMsgUserInfoGenerated(b *testing.B) { 
+	benchmarkRead(b, userinfo(), new(MsgUserInfo), generatedRead) 
+}
+func BenchmarkReadMsgUserInfoReflection(b *testing.B) { 
+	benchmarkRead(b, userinfo(), new(MsgUserInfo), reflectedRead) 
+}
+func BenchmarkReadMsgTalkGenerated(b *testing.B) { 
+	benchmarkRead(b, talk(), new(MsgTalk), generatedRead) 
+}
+func BenchmarkReadMsgTalkReflection(b *testing.B) { 
+	benchmarkRead(b, talk(), new(MsgTalk), reflectedRead) 
+}
@@ -0,0 +1,17 @@
+package packets
+
+// MsgEncryptCode is sent by the account server once the client connects, for 
+// patches from around 5180. It contains a random seed, which the client uses to 
+// generate the RC5 key for encrypting the password in MsgAccount (see 
+// security.RC5.Seed). http://conquer.wiki/doku.php?id=msgencryptcode
+type MsgEncryptCode struct {
+	PacketHeader
+	Seed uint32
+}
+
+func NewMsgEncryptCode(seed uint32) *MsgEncryptCode {
+	p := new(MsgEncryptCode)
+	p.Identifier = MSGENCRYPTCODE
+	p.Seed = seed
+	return p
+}
@@ -6,5 +6,24 @@ package packets
 // http://conquer.wiki/doku.php?id=msgitem
 type MsgItem struct {
 	PacketHeader
-	Identity, Argument, Action, Timestamp uint32
-}
\ No newline at end of file
+	Identity, Argument uint32
+	Action             MsgItemType
+	Timestamp          uint32
+}
+
+func NewMsgItem() *MsgItem {
+	p := new(MsgItem)
+	p.Identifier = MSGITEM
+	return p
+}
+
+type MsgItemType uint32
+const (
+	ITEM_BUY     MsgItemType = 1
+	ITEM_SELL    MsgItemType = 2
+	ITEM_DROP    MsgItemType = 3
+	ITEM_USE     MsgItemType = 4
+	ITEM_EQUIP   MsgItemType = 5
+	ITEM_UNEQUIP MsgItemType = 6
+	ITEM_PING    MsgItemType = 27
+)
@@ -2,8 +2,8 @@ package packets
 
 // MsgTalk is sent between the game client and server to exchange messages. The
 // messages are used to control the client during login to the game server, and to
-// socialize with other players on the server. The packet includes player meshes in
-// patches 5017 and higher for private whisper windows. Suffix is used for offline
+// socialize with other players on the server. The packet includes player meshes 
+// after patch 5017 for private whisper windows. Suffix is used for offline
 // messages, and usually contains the date in the format yyyyMMdd. 
 // http://conquer.wiki/doku.php?id=msgtalk
 type MsgTalk struct {
@@ -11,6 +11,8 @@ type MsgTalk struct {
 	Hue         uint32
 	Tone, Style uint16
 	Identity    uint32
+	Recipient   uint32 `since:"5018"` // Recipient's mesh.
+	Sender      uint32 `since:"5018"` // Sender's mesh.
 	Strings     []string
 }
 
@@ -1,127 +1,209 @@
 package packets
 
 import (
+	"bytes"
+	"encoding"
 	"errors"
 	"io"
 	"reflect"
-	"strings"
 	"strconv"
-	"unsafe"
 )
 
-// Read decodes a packet structure from binary data. Data must be a pointer to a 
-// structure of either a dynamic or fixed amount of memory. Bytes read from r are 
-// decoded using NetDragon's byte order and written to successive fields of the 
-// data. When reading into structs, the field data for fields with blank field 
+// Read decodes a packet structure from binary data. Data must be a pointer to a
+// structure of either a dynamic or fixed amount of memory. The remaining bytes in
+// r are decoded using NetDragon's byte order and written to successive fields of
+// the data. When reading into structs, the field data for fields with blank field
 // names are skipped (i.e., used for padding between values). All non-blank fields
-// must be exported.
+// must be exported. Field tags control the layout of strings, collections, and
+// sub-records, as described in the package documentation. Read decodes the layout
+// of the oldest patch; use ReadPatch for fields tagged with since.
+//
+// If data implements encoding.BinaryUnmarshaler, such as the methods generated by
+// packetgen, the bytes are passed to UnmarshalBinary instead of being decoded by
+// reflection. Errors are returned as a *FieldError naming the failing field.
 func Read(r io.Reader, data interface{}) error {
-	e := reflect.ValueOf(data).Elem()
-	if e.Kind() != reflect.Struct {
-		return errors.New("packets.Read: invalid type " + e.Kind().String())
+	return ReadPatch(r, data, 0)
+}
+
+// ReadPatch decodes a packet structure using the layout of a client patch. Fields
+// tagged since:"n" are only present in the binary data of patches n and higher;
+// for older patches, they're left unchanged. Generated UnmarshalPatch methods are
+// used when they exist.
+func ReadPatch(r io.Reader, data interface{}, patch uint16) error {
+	b, err := readall(r)
+	if err != nil { return err }
+	if u, ok := data.(patchUnmarshaler); ok { return u.UnmarshalPatch(b, patch) }
+	if u, ok := data.(encoding.BinaryUnmarshaler); ok {
+		return u.UnmarshalBinary(b)
 	}
-	
-	// Iterate through each field in the structure and read data. Switch on 
-	// the field's assigned data type for appropriate reading.
-	count := e.NumField()
-	for index := 0; index < count; index++ {
-		err := readfield(r, e.Field(index), e.Type().Field(index))
-		if err != nil { return err }
+	return readreflect(b, data, patch)
+}
+
+// patchUnmarshaler is implemented by the UnmarshalPatch methods generated by 
+// packetgen.
+type patchUnmarshaler interface {
+	UnmarshalPatch(data []byte, patch uint16) error
+}
+
+// readreflect decodes a packet structure from binary data using reflection. It's
+// used by Read for structures without a generated UnmarshalBinary method.
+func readreflect(b []byte, data interface{}, patch uint16) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("packets.Read: invalid type " + v.Kind().String())
+	}
+
+	e := v.Elem()
+	d := decoder { data: b }
+	d.patch = patch
+	readstruct(&d, e, e.Type().Name(), 0)
+	return d.err
+}
+
+// readstruct reads each field of a structure in order. Fields which aren't in the
+// patch's layout are skipped. Skip tags are applied once per field, before the 
+// field's value is read.
+func readstruct(d *decoder, v reflect.Value, name string, depth int) {
+	t := v.Type()
+	for i := 0; i < v.NumField() && d.err == nil; i++ {
+		f := t.Field(i)
+		field := name + "." + f.Name
+		if present, err := since(f.Tag, d.patch); err != nil || !present {
+			if err != nil { d.fail(field, err) }
+			continue
+		}
+		if skip := f.Tag.Get("skip"); skip != "" {
+			length, err := strconv.Atoi(skip)
+			if err != nil { d.fail(field, err); return }
+			d.skip(field, length)
+		}
+		readfield(d, v.Field(i), f.Tag, field, depth, f.PkgPath != "")
 	}
-	return nil
 }
 
-// readfield is the recursive function body for reading in values from a field. 
-// The Read function calls this function to read in a structure's body. It can 
-// also be called by itself to read in nested structures and arrays.
-func readfield(r io.Reader, f reflect.Value, t reflect.StructField) error {
-	
+// readfield is the recursive function body for reading in values from a field.
+// It's called by readstruct to read in a structure's fields, and by itself to read
+// in the elements of collections. Field names the field for errors, with "[]" for
+// each collection index.
+func readfield(d *decoder, f reflect.Value, tag reflect.StructTag, field string,
+	depth int, blank bool) {
+
+	// Blank and unexported fields are padding between values.
+	if blank {
+		length, err := blanksize(f.Type())
+		if err != nil { d.fail(field, err); return }
+		d.skip(field, length)
+		return
+	}
+
 	// Determine if this field is a nested structure or collection that requires a
 	// different type of handling.
 	switch f.Kind() {
 	case reflect.Struct:
-		for i := 0; i < f.NumField(); i++ {
-			err := readfield(r, f.Field(i), f.Type().Field(i))
-			if err != nil { return err }
+		if prefix := tag.Get("prefix"); prefix != "" {
+			w, err := width(prefix)
+			if err != nil { d.fail(field, err); return }
+			remaining := d.begin(field, w)
+			readstruct(d, f, field, depth)
+			if d.err == nil { d.end(remaining) }
+			return
 		}
-		return nil
-		
-	case reflect.Array:
-		for i := 0; i < f.Len(); i++ {
-			err := readfield(r, f.Index(i), t)
-			if err != nil { return err }
+		readstruct(d, f, field, depth)
+
+	case reflect.Array, reflect.Slice:
+		if depth >= MAX_DEPTH { d.fail(field, errors.New("nested too deeply")); return }
+		if f.Kind() == reflect.Slice {
+			w, err := width(tag.Get("count"))
+			if err != nil { d.fail(field, err); return }
+			count := d.count(field, w, minsize(f.Type().Elem(), tag))
+			f.Set(reflect.MakeSlice(f.Type(), count, count))
 		}
-		return nil
-	
-	case reflect.Slice:
-		b, err := readbytes(r, 1)
-		if err != nil { return err }
-		length := int(b[0])
-		
-		f.Set(reflect.MakeSlice(f.Type(), length, length))
-		for i := 0; i < length; i++ {
-			err := readfield(r, f.Index(i), t)
-			if err != nil { return err }
+		for i := 0; i < f.Len() && d.err == nil; i++ {
+			d.indexes[depth] = i
+			readfield(d, f.Index(i), tag, field + "[]", depth + 1, false)
 		}
-		return nil	
-	}
-	
-	// Determine the length of the read required to decode data from the binary 
-	// packet structure. Assume that if the kind isn't a special case, that the 
-	// field has a fixed size.
-	var length int
-	switch f.Kind() {
-	case reflect.String: 
-		if t.Tag != "" { // Fixed string.
-			fixedlength, err := strconv.Atoi(t.Tag.Get("len"))
-			if err != nil { return err }
-			length = fixedlength
-			
+
+	case reflect.String:
+		if length := tag.Get("len"); length != "" { // Fixed string.
+			fixedlength, err := strconv.Atoi(length)
+			if err != nil { d.fail(field, err); return }
+			f.SetString(d.fixed(field, fixedlength))
 		} else { // Dynamic string.
-			buffer, err := readbytes(r, 1)
-			if err != nil { return err }
-			length = int(buffer[0])
+			f.SetString(d.dynamic(field))
 		}
 
-	default: 
-		length = int(f.Type().Size())
+	case reflect.Bool:
+		f.SetBool(d.bool(field))
+	case reflect.Uint8:
+		f.SetUint(uint64(d.uint8(field)))
+	case reflect.Uint16:
+		f.SetUint(uint64(d.uint16(field)))
+	case reflect.Uint32:
+		f.SetUint(uint64(d.uint32(field)))
+	case reflect.Uint64:
+		f.SetUint(d.uint64(field))
+	case reflect.Int8:
+		f.SetInt(int64(int8(d.uint8(field))))
+	case reflect.Int16:
+		f.SetInt(int64(int16(d.uint16(field))))
+	case reflect.Int32:
+		f.SetInt(int64(int32(d.uint32(field))))
+	case reflect.Int64:
+		f.SetInt(int64(d.uint64(field)))
+
+	default:
+		d.fail(field, errors.New("unsupported kind " + f.Kind().String()))
 	}
-	
-	// Read the length of bytes into the buffer and switch according to the data
-	// type of the field.  Assume correctness and process write according to type.
-	b, err := readbytes(r, length)
-	if err != nil { return err }
-	if f.CanSet() {
-		switch f.Kind() {
-		case reflect.Uint8, reflect.Uint16, reflect.Uint32,
-			reflect.Uint64, reflect.Uintptr, reflect.Uint:
-			f.SetUint(*(*uint64)(unsafe.Pointer(&b[0])))
-		
-		case reflect.Int8, reflect.Int16, reflect.Int32,
-			reflect.Int64, reflect.Int:
-			f.SetInt(*(*int64)(unsafe.Pointer(&b[0])))
-		
-		case reflect.Bool:
-			f.SetBool(*(*bool)(unsafe.Pointer(&b[0])))
-		
-		case reflect.String: 
-			f.SetString(strings.TrimRight(string(b), "\x00"))
-			
-		default:
-			return errors.New("packets.read: Invalid kind detected: " +
-				f.Kind().String())
-		}
+}
+
+// readall returns the remaining bytes from the Reader interface. Buffers are read
+// without copying, since packets are decoded from the buffer holding the packet.
+func readall(r io.Reader) ([]byte, error) {
+	if buffer, ok := r.(*bytes.Buffer); ok { return buffer.Next(buffer.Len()), nil }
+	return io.ReadAll(r)
+}
+
+// blanksize returns the size of a blank field's padding. Blank fields must have a
+// fixed size: a boolean, integer, or array of them.
+func blanksize(t reflect.Type) (int, error) {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(t.Size()), nil
+	case reflect.Array:
+		size, err := blanksize(t.Elem())
+		return size * t.Len(), err
 	}
-	return nil
+	return 0, errors.New("unsupported blank kind " + t.Kind().String())
 }
 
-// readbytes is an internal function is called by a read function to read bytes of
-// a specified length from the Reader interface. If the read is successful, it will
-// return the byte array created for parsing; else, it will only return an error 
-// message.
-func readbytes(r io.Reader, length int) ([]byte, error) {
-	buffer := make([]byte, length)
-	if count, err := r.Read(buffer); err != nil || count < length {
-		return nil, errors.New("packets.readbytes: failed")
-	} else { return buffer, nil }
+// minsize returns the minimum number of bytes a value of the type is encoded in,
+// which bounds the element count of a collection against the data remaining. 
+// Fields tagged with since aren't counted, since they may not be present.
+func minsize(t reflect.Type, tag reflect.StructTag) int {
+	switch t.Kind() {
+	case reflect.Struct:
+		size := 0
+		if prefix := tag.Get("prefix"); prefix != "" {
+			w, _ := width(prefix)
+			size += w
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get("since") != "" { continue }
+			skip, _ := strconv.Atoi(f.Tag.Get("skip"))
+			size += skip + minsize(f.Type, f.Tag)
+		}
+		return size
+	case reflect.Array:
+		return t.Len() * minsize(t.Elem(), tag)
+	case reflect.Slice:
+		w, _ := width(tag.Get("count"))
+		return w
+	case reflect.String:
+		if length, err := strconv.Atoi(tag.Get("len")); err == nil { return length }
+		return 1
+	}
+	size, _ := blanksize(t)
+	return size
 }
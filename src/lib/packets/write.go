@@ -1,124 +1,165 @@
 package packets
 
 import (
+	"encoding"
 	"errors"
 	"io"
 	"reflect"
 	"strconv"
-	"unsafe"
 )
 
 // Write encodes a packet structure into binary data. Data must be a pointer to a
 // structure of either a dynamic or fixed amount of memory. Bytes written to w are
-// encoded using NetDragon's byte order and read from to successive fields of the 
-// data. When reading from structs, the field data for fields with blank field 
-// names are skipped (i.e., used for padding between values). All non-blank fields
-// must be exported.
+// encoded using NetDragon's byte order and read from to successive fields of the
+// data. When reading from structs, the field data for fields with blank field
+// names are written as zeros (i.e., used for padding between values). All
+// non-blank fields must be exported. Field tags control the layout of strings,
+// collections, and sub-records, as described in the package documentation. Write 
+// encodes the layout of the oldest patch; use WritePatch for fields tagged with 
+// since.
+//
+// If data implements encoding.BinaryMarshaler, such as the methods generated by
+// packetgen, the bytes from MarshalBinary are written instead of encoding the
+// structure by reflection. Errors are returned as a *FieldError naming the failing
+// field.
 func Write(w io.Writer, data interface{}) error {
-	e := reflect.ValueOf(data).Elem()
-	if e.Kind() != reflect.Struct {
-		return errors.New("packets.Write: invalid type " + e.Kind().String())
+	return WritePatch(w, data, 0)
+}
+
+// WritePatch encodes a packet structure using the layout of a client patch. Fields
+// tagged since:"n" are only written for patches n and higher. Generated 
+// MarshalPatch methods are used when they exist.
+func WritePatch(w io.Writer, data interface{}, patch uint16) error {
+	var b []byte
+	var err error
+	if m, ok := data.(patchMarshaler); ok {
+		b, err = m.MarshalPatch(patch)
+	} else if m, ok := data.(encoding.BinaryMarshaler); ok {
+		b, err = m.MarshalBinary()
+	} else { b, err = writereflect(data, patch) }
+	if err != nil { return err }
+	return writebytes(w, b)
+}
+
+// patchMarshaler is implemented by the MarshalPatch methods generated by 
+// packetgen.
+type patchMarshaler interface {
+	MarshalPatch(patch uint16) ([]byte, error)
+}
+
+// writereflect encodes a packet structure into binary data using reflection. It's
+// used by Write for structures without a generated MarshalBinary method.
+func writereflect(data interface{}, patch uint16) ([]byte, error) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("packets.Write: invalid type " + v.Kind().String())
 	}
-	
-	// Iterate through each field in the structure and write data. Switch on 
-	// the field's assigned data type for appropriate writing.
-	count := e.NumField()
-	for index := 0; index < count; index++ {
-		err := writefield(w, e.Field(index), e.Type().Field(index))
-		if err != nil { return err }
+
+	e := v.Elem()
+	enc := encoder { data: make([]byte, 0, minsize(e.Type(), "")) }
+	enc.patch = patch
+	writestruct(&enc, e, e.Type().Name(), 0)
+	return enc.data, enc.err
+}
+
+// writestruct writes each field of a structure in order. Fields which aren't in 
+// the patch's layout are skipped. Skip tags are applied once per field, before the
+// field's value is written.
+func writestruct(e *encoder, v reflect.Value, name string, depth int) {
+	t := v.Type()
+	for i := 0; i < v.NumField() && e.err == nil; i++ {
+		f := t.Field(i)
+		field := name + "." + f.Name
+		if present, err := since(f.Tag, e.patch); err != nil || !present {
+			if err != nil { e.fail(field, err) }
+			continue
+		}
+		if skip := f.Tag.Get("skip"); skip != "" {
+			length, err := strconv.Atoi(skip)
+			if err != nil { e.fail(field, err); return }
+			e.zero(length)
+		}
+		writefield(e, v.Field(i), f.Tag, field, depth, f.PkgPath != "")
 	}
-	return nil
 }
 
-// writefield is the recursive function body for writing values from a field. The
-// Write function calls this function to write a structure's body out to a binary 
-// writer. It can also be called by itself to write out nested structures and 
-// arrays.
-func writefield(w io.Writer, f reflect.Value, t reflect.StructField) error {
+// writefield is the recursive function body for writing values from a field. It's
+// called by writestruct to write out a structure's fields, and by itself to write
+// out the elements of collections. Field names the field for errors, with "[]" for
+// each collection index.
+func writefield(e *encoder, f reflect.Value, tag reflect.StructTag, field string,
+	depth int, blank bool) {
+
+	// Blank and unexported fields are padding between values.
+	if blank {
+		length, err := blanksize(f.Type())
+		if err != nil { e.fail(field, err); return }
+		e.zero(length)
+		return
+	}
 
-	// Determine if this field is a nested structure or collection that 
+	// Determine if this field is a nested structure or collection that
 	// requires a different type of handling.
 	switch f.Kind() {
 	case reflect.Struct:
-		for i := 0; i < f.NumField(); i++ {
-			err := writefield(w, f.Field(i), f.Type().Field(i))
-			if err != nil { return err }
+		if prefix := tag.Get("prefix"); prefix != "" {
+			w, err := width(prefix)
+			if err != nil { e.fail(field, err); return }
+			offset := e.begin(w)
+			writestruct(e, f, field, depth)
+			e.end(field, w, offset)
+			return
 		}
-		return nil
-	
-	case reflect.Array:
-		for i := 0; i < f.Len(); i++ {
-			err := writefield(w, f.Index(i), t)
-			if err != nil { return err }
+		writestruct(e, f, field, depth)
+
+	case reflect.Array, reflect.Slice:
+		if depth >= MAX_DEPTH { e.fail(field, errors.New("nested too deeply")); return }
+		if f.Kind() == reflect.Slice {
+			w, err := width(tag.Get("count"))
+			if err != nil { e.fail(field, err); return }
+			e.count(field, w, f.Len())
 		}
-		return nil
-		
-	case reflect.Slice:
-		err := writebytes(w, []byte { byte(f.Len()) })
-		if err != nil { return err }
-		for i := 0; i < f.Len(); i++ {
-			err := writefield(w, f.Index(i), t)
-			if err != nil { return err }
+		for i := 0; i < f.Len() && e.err == nil; i++ {
+			e.indexes[depth] = i
+			writefield(e, f.Index(i), tag, field + "[]", depth + 1, false)
 		}
-		return nil	
-	}
-	
-	// Determine the length of the write required to encode data to the binary 
-	// packet structure. Assume that if the kind isn't a special case, that the
-	// field has a fixed size.
-	var length int
-	switch f.Kind() {
-	case reflect.String: 
-		if t.Tag != "" { // Fixed string.
-			fixedlength, err := strconv.Atoi(t.Tag.Get("len"))
-			if err != nil { return err }
-			length = fixedlength
-			
+
+	case reflect.String:
+		if length := tag.Get("len"); length != "" { // Fixed string.
+			fixedlength, err := strconv.Atoi(length)
+			if err != nil { e.fail(field, err); return }
+			e.fixed(f.String(), fixedlength)
 		} else { // Dynamic string.
-			length = f.Len()
-			err := writebytes(w, []byte { byte(length) })
-			if err != nil { return err }
+			e.dynamic(field, f.String())
 		}
 
-	default: 
-		length = int(f.Type().Size())
-	}
-	
-	// Make a slice for the buffer being sent to the writebytes function according
-	// to the type of data being written. Assume correctness and process write 
-	// according to type.
-	b := make([]byte, length)
-	if b == nil { return errors.New("packets.write: make failed") }
-	if f.CanSet() {
-		switch f.Kind() {
-		case reflect.Uint8, reflect.Uint16, reflect.Uint32,
-			reflect.Uint64, reflect.Uintptr, reflect.Uint:
-			*(*uint64)(unsafe.Pointer(&b[0])) = f.Uint()
-			
-		case reflect.Int8, reflect.Int16, reflect.Int32,
-			reflect.Int64, reflect.Int:
-			*(*int64)(unsafe.Pointer(&b[0])) = f.Int()
-			
-		case reflect.Bool:
-			*(*bool)(unsafe.Pointer(&b[0])) = f.Bool()
-			
-		case reflect.String: 
-			copy(b, []byte(f.String()))
-			
-		default:
-			return errors.New("packets.write: invalid kind: " +
-				f.Kind().String())
-		}
+	case reflect.Bool:
+		e.bool(f.Bool())
+	case reflect.Uint8:
+		e.uint8(uint8(f.Uint()))
+	case reflect.Uint16:
+		e.uint16(uint16(f.Uint()))
+	case reflect.Uint32:
+		e.uint32(uint32(f.Uint()))
+	case reflect.Uint64:
+		e.uint64(f.Uint())
+	case reflect.Int8:
+		e.uint8(uint8(f.Int()))
+	case reflect.Int16:
+		e.uint16(uint16(f.Int()))
+	case reflect.Int32:
+		e.uint32(uint32(f.Int()))
+	case reflect.Int64:
+		e.uint64(uint64(f.Int()))
+
+	default:
+		e.fail(field, errors.New("unsupported kind " + f.Kind().String()))
 	}
-	
-	// Write bytes to the Writer interface.
-	err := writebytes(w, b)
-	return err	
 }
 
 // writebytes is an internal function is called by a write function to write bytes
 // of a specified length to the Writer interface. If the write is unsuccessful, it
-// will return an error message. 
+// will return an error message.
 func writebytes(w io.Writer, buffer []byte) error {
 	if count, err := w.Write(buffer); err != nil || count < len(buffer) {
 		return errors.New("packets.writebytes: failed")
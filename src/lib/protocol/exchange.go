@@ -0,0 +1,196 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"lib/security"
+	"strings"
+)
+
+// Seals appended to packets by profiles which are Sealed. The packet's length 
+// doesn't include the seal.
+const (
+	SEAL_SERVER = "TQServer"
+	SEAL_CLIENT = "TQClient"
+)
+
+// Definitions for the key exchange packets. The exchange packets don't have the 
+// packet header: they start with random padding, followed by the length of the 
+// rest of the packet. EXCHANGE_MAXIMUM limits the length read from the client.
+const (
+	EXCHANGE_SERVER_PADDING = 11
+	EXCHANGE_CLIENT_PADDING = 7
+	EXCHANGE_JUNK           = 12
+	EXCHANGE_MINIMUM        = 4 + 4 + 4 + len(SEAL_CLIENT)
+	EXCHANGE_MAXIMUM        = 1024
+)
+
+var (
+	ErrExchangeLength = errors.New("protocol.Exchange: invalid exchange length")
+	ErrExchangeFormat = errors.New("protocol.Exchange: malformed exchange")
+)
+
+// Exchange is the game server's side of the Diffie-Hellman key exchange for 
+// profiles using EXCHANGE_DH. The server sends its request once the client 
+// connects, encrypted with the Blowfish cipher's initial key. The client 
+// responds with its public key, which completes the exchange and keys the 
+// cipher. The request also carries the initialization vectors for the keyed 
+// cipher: one for each direction.
+type Exchange struct {
+	dh       *security.DiffieHellman
+	clientiv [8]byte
+	serveriv [8]byte
+}
+
+// NewExchange generates the keys and initialization vectors for a new exchange.
+func NewExchange() (*Exchange, error) {
+	dh, err := security.NewDiffieHellman()
+	if err != nil { return nil, err }
+	e := &Exchange { dh: dh }
+	if _, err := rand.Read(e.clientiv[:]); err != nil { return nil, err }
+	if _, err := rand.Read(e.serveriv[:]); err != nil { return nil, err }
+	return e, nil
+}
+
+// Request returns the server's exchange packet, unencrypted. Its layout is the 
+// padding, the length of the rest of the packet, junk, the client's and server's
+// initialization vectors, the prime, generator, and the server's public key, 
+// each prefixed with its length, then the server's seal.
+func (e *Exchange) Request() []byte {
+	junk := make([]byte, EXCHANGE_JUNK)
+	packet := make([]byte, EXCHANGE_SERVER_PADDING, 256)
+	rand.Read(packet)
+	rand.Read(junk)
+	packet = binary.LittleEndian.AppendUint32(packet, 0)
+	packet = appendfield(packet, junk)
+	packet = appendfield(packet, e.clientiv[:])
+	packet = appendfield(packet, e.serveriv[:])
+	packet = appendfield(packet, []byte(security.DH_PRIME))
+	packet = appendfield(packet, []byte(security.DH_GENERATOR))
+	packet = appendfield(packet, []byte(e.dh.PublicKey()))
+	packet = append(packet, SEAL_SERVER...)
+	binary.LittleEndian.PutUint32(packet[EXCHANGE_SERVER_PADDING:], 
+		uint32(len(packet) - EXCHANGE_SERVER_PADDING))
+	return packet
+}
+
+// Complete reads the client's public key from its exchange packet, then keys the
+// cipher with the shared secret and the exchange's initialization vectors. The 
+// client's packet has the same padding and length as ReadExchange, followed by
+// junk and the client's public key prefixed with their lengths, then the client's
+// seal.
+func (e *Exchange) Complete(packet []byte, cipher *security.Blowfish) error {
+	if len(packet) < EXCHANGE_CLIENT_PADDING + EXCHANGE_MINIMUM { 
+		return ErrExchangeFormat 
+	}
+	seal := len(packet) - len(SEAL_CLIENT)
+	if string(packet[seal:]) != SEAL_CLIENT { return ErrExchangeFormat }
+	data := packet[EXCHANGE_CLIENT_PADDING + 4:seal]
+	if _, ok := readfield(&data); !ok { return ErrExchangeFormat }
+	public, ok := readfield(&data)
+	if !ok { return ErrExchangeFormat }
+	key, err := e.dh.ComputeKey(string(public))
+	if err != nil { return err }
+	cipher.SetKey(key)
+	cipher.SetIVs(e.serveriv, e.clientiv)
+	return nil
+}
+
+// Respond is the client's side of the key exchange, for tools which replay 
+// sessions against the game server. It reads the server's request from r, 
+// decrypting it with the cipher's initial key, and returns the client's exchange
+// packet encrypted with the initial key. The cipher is then keyed with the shared
+// secret and the request's initialization vectors, so the packet must be sent 
+// before any other packet is encrypted.
+func Respond(r io.Reader, cipher *security.Blowfish) ([]byte, error) {
+	request, err := readexchange(r, cipher, EXCHANGE_SERVER_PADDING)
+	if err != nil { return nil, err }
+	seal := len(request) - len(SEAL_SERVER)
+	if string(request[seal:]) != SEAL_SERVER { return nil, ErrExchangeFormat }
+	
+	// Read the junk, initialization vectors, prime, generator, and public key. 
+	// The prime and generator are the same for every client.
+	data := request[EXCHANGE_SERVER_PADDING + 4:seal]
+	fields := make([][]byte, 6)
+	for i := range fields {
+		value, ok := readfield(&data)
+		if !ok { return nil, ErrExchangeFormat }
+		fields[i] = value
+	}
+	if len(fields[1]) != 8 || len(fields[2]) != 8 || 
+		!strings.EqualFold(string(fields[3]), security.DH_PRIME) ||
+		!strings.EqualFold(string(fields[4]), security.DH_GENERATOR) {
+		return nil, ErrExchangeFormat
+	}
+	dh, err := security.NewDiffieHellman()
+	if err != nil { return nil, err }
+	key, err := dh.ComputeKey(string(fields[5]))
+	if err != nil { return nil, err }
+	
+	// Respond with the client's public key, then key the cipher.
+	junk := make([]byte, EXCHANGE_JUNK)
+	packet := make([]byte, EXCHANGE_CLIENT_PADDING, 256)
+	rand.Read(packet)
+	rand.Read(junk)
+	packet = binary.LittleEndian.AppendUint32(packet, 0)
+	packet = appendfield(packet, junk)
+	packet = appendfield(packet, []byte(dh.PublicKey()))
+	packet = append(packet, SEAL_CLIENT...)
+	binary.LittleEndian.PutUint32(packet[EXCHANGE_CLIENT_PADDING:], 
+		uint32(len(packet) - EXCHANGE_CLIENT_PADDING))
+	cipher.Encrypt(packet)
+	var clientiv, serveriv [8]byte
+	copy(clientiv[:], fields[1])
+	copy(serveriv[:], fields[2])
+	cipher.SetKey(key)
+	cipher.SetIVs(clientiv, serveriv)
+	return packet, nil
+}
+
+// ReadExchange reads the client's exchange packet from r, decrypting it with the
+// cipher's initial key. The length of the packet follows the padding, so the 
+// padding and length are read first, then the remaining bytes of the packet. 
+// The whole packet is returned, decrypted.
+func ReadExchange(r io.Reader, cipher security.Cipher) ([]byte, error) {
+	return readexchange(r, cipher, EXCHANGE_CLIENT_PADDING)
+}
+
+// readexchange reads an exchange packet with the given length of padding, from 
+// either side of the exchange.
+func readexchange(r io.Reader, cipher security.Cipher, padding int) ([]byte, 
+	error) {
+	
+	header := make([]byte, padding + 4)
+	if _, err := io.ReadFull(r, header); err != nil { return nil, err }
+	cipher.Decrypt(header)
+	length := int(binary.LittleEndian.Uint32(header[padding:]))
+	if length < EXCHANGE_MINIMUM || length > EXCHANGE_MAXIMUM { 
+		return nil, ErrExchangeLength 
+	}
+	
+	// Read the remaining bytes of the packet.
+	packet := make([]byte, padding + length)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[len(header):]); err != nil { return nil, err }
+	cipher.Decrypt(packet[len(header):])
+	return packet, nil
+}
+
+// appendfield appends a value prefixed with its length.
+func appendfield(packet []byte, value []byte) []byte {
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(len(value)))
+	return append(packet, value...)
+}
+
+// readfield reads a value prefixed with its length from the front of data. 
+// Returns false if the value runs past the end of the data.
+func readfield(data *[]byte) ([]byte, bool) {
+	if len(*data) < 4 { return nil, false }
+	length := binary.LittleEndian.Uint32(*data)
+	if uint64(length) > uint64(len(*data) - 4) { return nil, false }
+	value := (*data)[4:4 + length]
+	*data = (*data)[4 + length:]
+	return value, true
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"lib/security"
+	"math/big"
+	"testing"
+)
+
+// TestExchange runs both sides of the key exchange, with the client's side 
+// written from the packet layouts, and checks that the keyed ciphers agree.
+func TestExchange(t *testing.T) {
+	exchange, err := NewExchange()
+	if err != nil { t.Fatal(err) }
+	server, client := &security.Blowfish {}, &security.Blowfish {}
+	server.Init()
+	client.Init()
+	
+	// Parse the server's request as the client.
+	request := exchange.Request()
+	server.Encrypt(request)
+	client.Decrypt(request)
+	if string(request[len(request) - len(SEAL_SERVER):]) != SEAL_SERVER {
+		t.Fatalf("request is missing the server's seal")
+	}
+	length := binary.LittleEndian.Uint32(request[EXCHANGE_SERVER_PADDING:])
+	if int(length) != len(request) - EXCHANGE_SERVER_PADDING {
+		t.Fatalf("request length %d, want %d", length, 
+			len(request) - EXCHANGE_SERVER_PADDING)
+	}
+	data := request[EXCHANGE_SERVER_PADDING + 4:]
+	fields := make([][]byte, 6)
+	for i := range fields {
+		value, ok := readfield(&data)
+		if !ok { t.Fatalf("request field %d is truncated", i) }
+		fields[i] = value
+	}
+	prime, _ := new(big.Int).SetString(string(fields[3]), 16)
+	generator, _ := new(big.Int).SetString(string(fields[4]), 16)
+	public, _ := new(big.Int).SetString(string(fields[5]), 16)
+	
+	// Respond with the client's public key, then key the client's cipher.
+	private := big.NewInt(0x1234567890ABCDEF)
+	key := new(big.Int).Exp(generator, private, prime)
+	response := make([]byte, EXCHANGE_CLIENT_PADDING + 4)
+	response = appendfield(response, []byte("junk"))
+	response = appendfield(response, []byte(key.Text(16)))
+	response = append(response, SEAL_CLIENT...)
+	binary.LittleEndian.PutUint32(response[EXCHANGE_CLIENT_PADDING:], 
+		uint32(len(response) - EXCHANGE_CLIENT_PADDING))
+	client.Encrypt(response)
+	
+	packet, err := ReadExchange(bytes.NewReader(response), server)
+	if err != nil { t.Fatal(err) }
+	if err := exchange.Complete(packet, server); err != nil { t.Fatal(err) }
+	client.SetKey(new(big.Int).Exp(public, private, prime).Bytes())
+	var clientiv, serveriv [8]byte
+	copy(clientiv[:], fields[1])
+	copy(serveriv[:], fields[2])
+	client.SetIVs(clientiv, serveriv)
+	
+	// Packets must decrypt in both directions.
+	message := []byte("MsgConnect" + SEAL_CLIENT)
+	buffer := append([]byte(nil), message...)
+	client.Encrypt(buffer)
+	server.Decrypt(buffer)
+	if !bytes.Equal(buffer, message) { t.Errorf("server decrypted %q", buffer) }
+	message = []byte("MsgTalk" + SEAL_SERVER)
+	buffer = append(buffer[:0], message...)
+	server.Encrypt(buffer)
+	client.Decrypt(buffer)
+	if !bytes.Equal(buffer, message) { t.Errorf("client decrypted %q", buffer) }
+}
+
+// TestRespond checks the client's side of the exchange against the server's.
+func TestRespond(t *testing.T) {
+	exchange, err := NewExchange()
+	if err != nil { t.Fatal(err) }
+	server, client := &security.Blowfish {}, &security.Blowfish {}
+	server.Init()
+	client.Init()
+	request := exchange.Request()
+	server.Encrypt(request)
+	response, err := Respond(bytes.NewReader(request), client)
+	if err != nil { t.Fatal(err) }
+	packet, err := ReadExchange(bytes.NewReader(response), server)
+	if err != nil { t.Fatal(err) }
+	if err := exchange.Complete(packet, server); err != nil { t.Fatal(err) }
+	
+	message := []byte("MsgConnect" + SEAL_CLIENT)
+	buffer := append([]byte(nil), message...)
+	client.Encrypt(buffer)
+	server.Decrypt(buffer)
+	if !bytes.Equal(buffer, message) { t.Errorf("server decrypted %q", buffer) }
+	message = []byte("MsgTalk" + SEAL_SERVER)
+	buffer = append(buffer[:0], message...)
+	server.Encrypt(buffer)
+	client.Decrypt(buffer)
+	if !bytes.Equal(buffer, message) { t.Errorf("client decrypted %q", buffer) }
+	
+	// A request without the server's seal is rejected.
+	request = exchange.Request()
+	copy(request[len(request) - len(SEAL_SERVER):], "TQClient")
+	client.Init()
+	encrypt := &security.Blowfish {}
+	encrypt.Init()
+	encrypt.Encrypt(request)
+	if _, err := Respond(bytes.NewReader(request), client); err != ErrExchangeFormat {
+		t.Errorf("unsealed request: got %v, want %v", err, ErrExchangeFormat)
+	}
+}
+
+func TestReadExchangeLength(t *testing.T) {
+	for _, length := range []int { 0, EXCHANGE_MINIMUM - 1, EXCHANGE_MAXIMUM + 1 } {
+		cipher := &security.Blowfish {}
+		cipher.Init()
+		packet := make([]byte, EXCHANGE_CLIENT_PADDING + 4)
+		binary.LittleEndian.PutUint32(packet[EXCHANGE_CLIENT_PADDING:], uint32(length))
+		encrypt := &security.Blowfish {}
+		encrypt.Init()
+		encrypt.Encrypt(packet)
+		if _, err := ReadExchange(bytes.NewReader(packet), cipher); err != ErrExchangeLength {
+			t.Errorf("length %d: got %v, want %v", length, err, ErrExchangeLength)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Protocol defines the profiles of the client patches supported by the servers.
+// The game client's protocol changed between patches: the game server's cipher 
+// was replaced by Blowfish with a key exchange after patch 5017, and packet 
+// layouts grew new fields (such as the meshes in MsgTalk after patch 5017). A 
+// profile groups a range of patches which share a cipher and key exchange. The 
+// game server starts each connection with its listener's profile, then selects 
+// the profile and layout patch from the client's version in MsgConnect, so one 
+// deployment can serve several client patches.
+package protocol
+
+import (
+	"errors"
+	"lib/security"
+	"strconv"
+	"strings"
+)
+
+// Ciphers used by the game server's profiles.
+const (
+	CIPHER_TQ       = "tq"
+	CIPHER_BLOWFISH = "blowfish"
+)
+
+// Key exchanges used by the game server's profiles. EXCHANGE_MSGCONNECT generates
+// the cipher's keys from the token and identity in MsgConnect, without a separate
+// exchange. EXCHANGE_DH keys the cipher with a Diffie-Hellman key exchange before
+// MsgConnect is sent (see Exchange).
+const (
+	EXCHANGE_MSGCONNECT = "msgconnect"
+	EXCHANGE_DH         = "dh"
+)
+
+// DEFAULT_PROFILE is the name of the profile used by listeners which don't 
+// specify one.
+const DEFAULT_PROFILE = "tqcipher"
+
+var (
+	ErrInvalidVersion = errors.New("invalid client version")
+	ErrUnsupported    = errors.New("unsupported client patch")
+)
+
+// Profile defines the protocol for a range of client patches: the cipher used by
+// the game server, the key exchange which keys the cipher, and the range of 
+// packet layouts. Minimum and Maximum are inclusive; a Maximum of zero has no 
+// upper limit. Packet layouts are selected by the client's patch within the 
+// range, using the since tags on packet fields (see lib/packets). If Sealed is
+// set, every packet after the key exchange ends with a seal, which isn't included
+// in the packet's length (SEAL_SERVER from the server, SEAL_CLIENT from the 
+// client). If Seeded is set, the account server sends MsgEncryptCode with a 
+// random seed for the client's password cipher.
+type Profile struct {
+	Name     string
+	Minimum  uint16
+	Maximum  uint16
+	Cipher   string
+	Exchange string
+	Sealed   bool
+	Seeded   bool
+}
+
+// Profiles is the list of supported profiles, in order of patch.
+var Profiles = []*Profile {
+	{ Name: "tqcipher", Minimum: 0, Maximum: 5017, Cipher: CIPHER_TQ, 
+		Exchange: EXCHANGE_MSGCONNECT },
+	{ Name: "blowfish", Minimum: 5018, Maximum: 5179, Cipher: CIPHER_BLOWFISH, 
+		Exchange: EXCHANGE_DH, Sealed: true },
+	{ Name: "seeded", Minimum: 5180, Maximum: 0, Cipher: CIPHER_BLOWFISH, 
+		Exchange: EXCHANGE_DH, Sealed: true, Seeded: true },
+}
+
+// Lookup returns the profile with the given name, or nil if it doesn't exist.
+func Lookup(name string) *Profile {
+	for _, profile := range Profiles {
+		if profile.Name == name { return profile }
+	}
+	return nil
+}
+
+// Select returns the profile for a client version from MsgConnect, and the 
+// version as a patch number for selecting packet layouts.
+func Select(version string) (*Profile, uint16, error) {
+	patch, err := strconv.ParseUint(strings.TrimSpace(version), 10, 16)
+	if err != nil { return nil, 0, ErrInvalidVersion }
+	for _, profile := range Profiles {
+		if profile.Contains(uint16(patch)) { return profile, uint16(patch), nil }
+	}
+	return nil, 0, ErrUnsupported
+}
+
+// Contains returns true if the patch is in the profile's range.
+func (p *Profile) Contains(patch uint16) bool {
+	return patch >= p.Minimum && (p.Maximum == 0 || patch <= p.Maximum)
+}
+
+// Compatible returns true if a client connected using another profile can switch
+// to this profile. The cipher and key exchange start before the client's version
+// is known, so they must be the same (along with the seals, which follow the
+// exchange). Seeded only applies to the account server, so it's not compared.
+func (p *Profile) Compatible(other *Profile) bool {
+	return p.Cipher == other.Cipher && p.Exchange == other.Exchange &&
+		p.Sealed == other.Sealed
+}
+
+// NewCipher creates a new instance of the profile's cipher for the game server.
+// The cipher must be initialized before use.
+func (p *Profile) NewCipher() security.Cipher {
+	switch p.Cipher {
+	case CIPHER_TQ: return new(security.TQCipher)
+	case CIPHER_BLOWFISH: return new(security.Blowfish)
+	}
+	return nil
+}
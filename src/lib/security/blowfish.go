@@ -0,0 +1,131 @@
+package security
+
+import "encoding/binary"
+
+//go:generate go run gentables.go
+
+// BLOWFISH_KEY is the initial key for the game server's Blowfish cipher, used to
+// encrypt the key exchange before the shared key has been negotiated. It's 
+// targeted for the Conquer Online game client for patches 5018 and higher.
+const BLOWFISH_KEY = "DR654dt34trg4UI6"
+
+// BLOWFISH_ROUNDS is the number of Feistel rounds in the Blowfish algorithm.
+const BLOWFISH_ROUNDS = 16
+
+// Blowfish replaced the TQCipher on the game server from patch 5018. It's 
+// Bruce Schneier's Blowfish block cipher in 64-bit cipher feedback mode, as 
+// implemented by OpenSSL's BF_cfb64_encrypt (which the client uses). The cipher
+// starts with the initial key, then is keyed by the Diffie-Hellman key exchange
+// with SetKey and SetIVs. Encryption and decryption keep separate feedback 
+// registers, so packets can be encrypted and decrypted from different go 
+// routines once the cipher has been keyed.
+type Blowfish struct {
+	p          [BLOWFISH_ROUNDS + 2]uint32
+	s          [4][256]uint32
+	encryptiv  [8]byte
+	decryptiv  [8]byte
+	encryptnum int
+	decryptnum int
+}
+
+// Init keys the cipher with the initial key and clears the feedback registers,
+// for encrypting and decrypting the key exchange.
+func (c *Blowfish) Init() {
+	c.SetKey([]byte(BLOWFISH_KEY))
+	c.SetIVs([8]byte {}, [8]byte {})
+}
+
+// Generate does nothing for Blowfish. The cipher's keys are negotiated by the 
+// key exchange before MsgConnect is received, rather than generated from it.
+func (c *Blowfish) Generate(token, identity uint32) { }
+
+// SetKey generates the P-array and S-boxes from a key according to the Blowfish
+// specifications. Keys longer than the P-array (72 bytes) are truncated, as in 
+// OpenSSL, which accepts longer keys than the 56 bytes in the specification. 
+// The feedback registers aren't changed.
+func (c *Blowfish) SetKey(key []byte) {
+	c.p, c.s = blowfishP, blowfishS
+	if len(key) > 4 * len(c.p) { key = key[:4 * len(c.p)] }
+	if len(key) == 0 { return }
+	
+	// XOR the P-array with the key, repeating the key as required.
+	j := 0
+	for i := range c.p {
+		var word uint32
+		for k := 0; k < 4; k++ {
+			word = word << 8 | uint32(key[j])
+			j = (j + 1) % len(key)
+		}
+		c.p[i] ^= word
+	}
+	
+	// Replace the P-array and S-boxes with the output of the cipher, encrypting 
+	// from a zero block.
+	var l, r uint32
+	for i := 0; i < len(c.p); i += 2 {
+		l, r = c.block(l, r)
+		c.p[i], c.p[i + 1] = l, r
+	}
+	for i := range c.s {
+		for j := 0; j < 256; j += 2 {
+			l, r = c.block(l, r)
+			c.s[i][j], c.s[i][j + 1] = l, r
+		}
+	}
+}
+
+// SetIVs sets the initialization vectors for encryption and decryption, and 
+// restarts both feedback registers.
+func (c *Blowfish) SetIVs(encrypt, decrypt [8]byte) {
+	c.encryptiv, c.decryptiv = encrypt, decrypt
+	c.encryptnum, c.decryptnum = 0, 0
+}
+
+// Decrypt decrypts a buffer received from the client. Init must be called before
+// first time use.
+func (c *Blowfish) Decrypt(buffer []byte) {
+	for i := 0; i < len(buffer); i++ {
+		if c.decryptnum == 0 { c.feedback(&c.decryptiv) }
+		value := buffer[i]
+		buffer[i] ^= c.decryptiv[c.decryptnum]
+		c.decryptiv[c.decryptnum] = value
+		c.decryptnum = (c.decryptnum + 1) & 7
+	}
+}
+
+// Encrypt encrypts a buffer to be sent to the client. Init must be called before
+// first time use.
+func (c *Blowfish) Encrypt(buffer []byte) {
+	for i := 0; i < len(buffer); i++ {
+		if c.encryptnum == 0 { c.feedback(&c.encryptiv) }
+		buffer[i] ^= c.encryptiv[c.encryptnum]
+		c.encryptiv[c.encryptnum] = buffer[i]
+		c.encryptnum = (c.encryptnum + 1) & 7
+	}
+}
+
+// feedback encrypts a feedback register in place, as a big endian block.
+func (c *Blowfish) feedback(iv *[8]byte) {
+	l, r := c.block(binary.BigEndian.Uint32(iv[0:4]), binary.BigEndian.Uint32(iv[4:8]))
+	binary.BigEndian.PutUint32(iv[0:4], l)
+	binary.BigEndian.PutUint32(iv[4:8], r)
+}
+
+// block encrypts a single 64-bit block, given as its left and right halves.
+func (c *Blowfish) block(l, r uint32) (uint32, uint32) {
+	for i := 0; i < BLOWFISH_ROUNDS; i += 2 {
+		l ^= c.p[i]
+		r ^= c.f(l)
+		r ^= c.p[i + 1]
+		l ^= c.f(r)
+	}
+	l ^= c.p[BLOWFISH_ROUNDS]
+	r ^= c.p[BLOWFISH_ROUNDS + 1]
+	return r, l
+}
+
+// f is Blowfish's round function, which mixes the S-boxes using the bytes of x.
+func (c *Blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x >> 24] + c.s[1][x >> 16 & 0xFF]) ^ c.s[2][x >> 8 & 0xFF]) + 
+		c.s[3][x & 0xFF]
+}
@@ -0,0 +1,64 @@
+package security
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Definitions for the game server's Diffie-Hellman key exchange, as hexadecimal
+// strings. The prime and generator are sent to the client in the exchange, but 
+// are the same for every client from patch 5018.
+const (
+	DH_PRIME     = "E7A69EBDF105F2A6BBDEAD7E798F76A209AD73FB466431E2E7352ED262F8C558" +
+		"F10BEFEA977DE9E21DCEE9B04D245F300ECCBBA03E72630556D011023F9E857F"
+	DH_GENERATOR = "05"
+)
+
+var ErrInvalidPublicKey = errors.New("security.DiffieHellman: invalid public key")
+
+// DiffieHellman is the server's side of the Diffie-Hellman key exchange which 
+// keys the Blowfish cipher. Each connection generates a new private key; the 
+// public keys are exchanged as hexadecimal strings, and the shared secret is used
+// as the Blowfish key.
+type DiffieHellman struct {
+	Prime     *big.Int
+	Generator *big.Int
+	private   *big.Int
+	public    *big.Int
+}
+
+// NewDiffieHellman generates a new private and public key using the game 
+// server's prime and generator.
+func NewDiffieHellman() (*DiffieHellman, error) {
+	dh := &DiffieHellman { }
+	dh.Prime, _ = new(big.Int).SetString(DH_PRIME, 16)
+	dh.Generator, _ = new(big.Int).SetString(DH_GENERATOR, 16)
+	
+	// Choose a private key in [2, p - 2].
+	limit := new(big.Int).Sub(dh.Prime, big.NewInt(3))
+	private, err := rand.Int(rand.Reader, limit)
+	if err != nil { return nil, err }
+	dh.private = private.Add(private, big.NewInt(2))
+	dh.public = new(big.Int).Exp(dh.Generator, dh.private, dh.Prime)
+	return dh, nil
+}
+
+// PublicKey returns the server's public key as an uppercase hexadecimal string.
+func (dh *DiffieHellman) PublicKey() string {
+	return strings.ToUpper(dh.public.Text(16))
+}
+
+// ComputeKey returns the shared secret from the client's public key as big 
+// endian bytes, without leading zeros (as returned by OpenSSL's DH_compute_key).
+// Public keys outside of [2, p - 2] are rejected, since they'd force the secret
+// to a known value.
+func (dh *DiffieHellman) ComputeKey(public string) ([]byte, error) {
+	key, ok := new(big.Int).SetString(strings.TrimRight(public, "\x00"), 16)
+	limit := new(big.Int).Sub(dh.Prime, big.NewInt(1))
+	if !ok || key.Cmp(big.NewInt(1)) <= 0 || key.Cmp(limit) >= 0 {
+		return nil, ErrInvalidPublicKey
+	}
+	return new(big.Int).Exp(key, dh.private, dh.Prime).Bytes(), nil
+}
@@ -0,0 +1,78 @@
+//go:build ignore
+
+// Gentables generates the Blowfish P-array and S-boxes from the fractional digits
+// of pi, as specified by Bruce Schneier, and writes them to blowfish_tables.go. 
+// It's run by go generate from blowfish.go:
+//
+//	go run gentables.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// WORDS is the number of 32-bit words needed for the P-array and S-boxes.
+const WORDS = 18 + 4 * 256
+
+func main() {
+	// Calculate pi in fixed point with a few guard digits, using Machin's formula:
+	// pi = 16 * arctan(1/5) - 4 * arctan(1/239).
+	bits := uint(WORDS * 32 + 64)
+	one := new(big.Int).Lsh(big.NewInt(1), bits)
+	pi := new(big.Int).Mul(big.NewInt(16), arctan(one, 5))
+	pi.Sub(pi, new(big.Int).Mul(big.NewInt(4), arctan(one, 239)))
+	
+	// Take the fractional part and shift out the guard digits.
+	pi.Mod(pi, one)
+	pi.Rsh(pi, 64)
+	digits := fmt.Sprintf("%0*x", WORDS * 8, pi)
+	words := make([]string, WORDS)
+	for i := range words { words[i] = "0x" + digits[i * 8:i * 8 + 8] }
+	
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gentables.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package security\n\n")
+	fmt.Fprintf(&b, "// blowfishP is the initial Blowfish P-array, from the digits of pi.\n")
+	fmt.Fprintf(&b, "var blowfishP = [18]uint32 {")
+	table(&b, words[:18])
+	fmt.Fprintf(&b, "}\n\n")
+	fmt.Fprintf(&b, "// blowfishS is the initial Blowfish S-boxes, from the digits of pi.\n")
+	fmt.Fprintf(&b, "var blowfishS = [4][256]uint32 {\n")
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&b, "\t{")
+		table(&b, words[18 + i * 256:18 + (i + 1) * 256])
+		fmt.Fprintf(&b, "},\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	if err := os.WriteFile("blowfish_tables.go", b.Bytes(), 0664); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// arctan returns arctan(1/x) in fixed point, scaled by one, using the Taylor 
+// series: 1/x - 1/(3x^3) + 1/(5x^5) - ...
+func arctan(one *big.Int, x int64) *big.Int {
+	sum := new(big.Int)
+	power := new(big.Int).Div(one, big.NewInt(x))
+	square := big.NewInt(x * x)
+	term := new(big.Int)
+	for n := int64(1); power.Sign() != 0; n += 2 {
+		term.Div(power, big.NewInt(n))
+		if n % 4 == 1 { sum.Add(sum, term) } else { sum.Sub(sum, term) }
+		power.Div(power, square)
+	}
+	return sum
+}
+
+// table writes words as the body of an array literal, eight per line.
+func table(b *bytes.Buffer, words []string) {
+	for i, word := range words {
+		if i % 8 == 0 { fmt.Fprintf(b, "\n\t\t") } else { fmt.Fprintf(b, " ") }
+		fmt.Fprintf(b, "%s,", word)
+	}
+	fmt.Fprintf(b, "\n\t")
+}
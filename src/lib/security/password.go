@@ -0,0 +1,82 @@
+package security
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Definitions for password hashes. Hashes are stored as the scheme, iterations,
+// salt, and derived key separated by dollar signs, with the salt and key in 
+// unpadded base64 (for example, "pbkdf2-sha256$600000$<salt>$<key>"). Hashes 
+// with fewer iterations than PASSWORD_ITERATIONS still verify, but should be 
+// rehashed.
+const (
+	PASSWORD_SCHEME     = "pbkdf2-sha256"
+	PASSWORD_ITERATIONS = 600000
+	PASSWORD_SALT       = 16
+	PASSWORD_KEY        = 32
+)
+
+var ErrInvalidHash = errors.New("security.Password: invalid password hash")
+
+// HashPassword hashes a password with PBKDF2-SHA256 and a random salt, returning
+// the hash in the versioned format above.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, PASSWORD_SALT)
+	if _, err := rand.Read(salt); err != nil { return "", err }
+	key, err := pbkdf2.Key(sha256.New, password, salt, PASSWORD_ITERATIONS, 
+		PASSWORD_KEY)
+	if err != nil { return "", err }
+	return strings.Join([]string { PASSWORD_SCHEME, 
+		strconv.Itoa(PASSWORD_ITERATIONS), 
+		base64.RawStdEncoding.EncodeToString(salt), 
+		base64.RawStdEncoding.EncodeToString(key) }, "$"), nil
+}
+
+// VerifyPassword checks a password decrypted from MsgAccount against a stored 
+// hash. The password is the whole decrypted field: PBKDF2 hashes are of the 
+// password without its null padding, but legacy hashes (the SHA-1 hex of the 
+// padded field, from before hashes were versioned) are of the whole field. 
+// Comparisons are made in constant time. Upgrade is true if the password is 
+// valid but the hash should be replaced with a new hash from HashPassword.
+func VerifyPassword(password []byte, hash string) (valid, upgrade bool) {
+	if !strings.Contains(hash, "$") { // Legacy SHA-1 hash.
+		digest := sha1.Sum(password)
+		encoded := hex.EncodeToString(digest[:])
+		valid = subtle.ConstantTimeCompare([]byte(encoded), 
+			[]byte(strings.ToLower(hash))) == 1
+		return valid, valid
+	}
+	
+	iterations, salt, expected, err := parsehash(hash)
+	if err != nil { return false, false }
+	plain := string(bytes.TrimRight(password, "\x00"))
+	key, err := pbkdf2.Key(sha256.New, plain, salt, iterations, len(expected))
+	if err != nil { return false, false }
+	valid = subtle.ConstantTimeCompare(key, expected) == 1
+	return valid, valid && iterations < PASSWORD_ITERATIONS
+}
+
+// parsehash splits a versioned hash into its iterations, salt, and key.
+func parsehash(hash string) (int, []byte, []byte, error) {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 4 || fields[0] != PASSWORD_SCHEME { 
+		return 0, nil, nil, ErrInvalidHash 
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations < 1 { return 0, nil, nil, ErrInvalidHash }
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil { return 0, nil, nil, ErrInvalidHash }
+	key, err := base64.RawStdEncoding.DecodeString(fields[3])
+	if err != nil || len(key) == 0 { return 0, nil, nil, ErrInvalidHash }
+	return iterations, salt, key, nil
+}
@@ -0,0 +1,57 @@
+package security
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("test")
+	if err != nil { t.Fatal(err) }
+	if !strings.HasPrefix(hash, PASSWORD_SCHEME + "$") { t.Fatalf("hash %q", hash) }
+	
+	// The password is verified from the padded field sent by the client.
+	field := make([]byte, 16)
+	copy(field, "test")
+	if valid, upgrade := VerifyPassword(field, hash); !valid || upgrade {
+		t.Errorf("valid %v, upgrade %v; want true, false", valid, upgrade)
+	}
+	copy(field, "tess")
+	if valid, _ := VerifyPassword(field, hash); valid { t.Errorf("wrong password valid") }
+	if other, _ := HashPassword("test"); other == hash { t.Errorf("hashes aren't salted") }
+	
+	// Hashes with fewer iterations than the current setting are upgraded.
+	copy(field, "test")
+	key, _ := pbkdf2.Key(sha256.New, "test", []byte("salt"), 1000, PASSWORD_KEY)
+	weak := "pbkdf2-sha256$1000$c2FsdA$" + base64.RawStdEncoding.EncodeToString(key)
+	if valid, upgrade := VerifyPassword(field, weak); !valid || !upgrade {
+		t.Errorf("weak hash: valid %v, upgrade %v; want true, true", valid, upgrade)
+	}
+}
+
+// TestPasswordLegacy checks the SHA-1 hash of the test account, which must be 
+// valid and upgraded.
+func TestPasswordLegacy(t *testing.T) {
+	field := make([]byte, 16)
+	copy(field, "test")
+	legacy := "4dc8c43d1b4f3a3b4530623f4d25406646474283"
+	if valid, upgrade := VerifyPassword(field, legacy); !valid || !upgrade {
+		t.Errorf("valid %v, upgrade %v; want true, true", valid, upgrade)
+	}
+	copy(field, "tess")
+	if valid, upgrade := VerifyPassword(field, legacy); valid || upgrade {
+		t.Errorf("wrong password valid %v, upgrade %v", valid, upgrade)
+	}
+}
+
+func TestPasswordInvalid(t *testing.T) {
+	field := []byte("test")
+	weak := "pbkdf2-sha256$1$c2FsdA$"
+	for _, hash := range []string { "", "md5$1$c2FsdA$a2V5", "pbkdf2-sha256$x$c2FsdA$a2V5", 
+		"pbkdf2-sha256$0$c2FsdA$a2V5", weak } {
+		if valid, _ := VerifyPassword(field, hash); valid { t.Errorf("%q is valid", hash) }
+	}
+}
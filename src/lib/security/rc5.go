@@ -17,11 +17,38 @@ type RC5 struct {
 
 // Init is called during authentication routine in the account server. Does not
 // require input seed or prior initialization during OnConnect event unless using
-// patches higher than around 5175.
+// patches higher than around 5175, which use Seed instead.
 func (cipher *RC5) Init() {
+	cipher.Key = [...]uint32 { 0xE8FEDC3C, 0x7ED654C4, 0x1AF8A616, 0xBE38D0E8 }
+	cipher.expand()
+}
+
+// Seed is called in place of Init for patches from around 5180, where the key is
+// generated from the seed sent to the client in MsgEncryptCode. The client seeds
+// the C runtime's random number generator (MSVC's srand) with it, then takes the
+// low byte of each of the next 16 random numbers as the key.
+func (cipher *RC5) Seed(seed uint32) {
+	cipher.Key = seedkey(seed)
+	cipher.expand()
+}
+
+// seedkey generates the RC5 key for a seed using MSVC's linear congruential
+// generator, which is what the client's rand function implements.
+func seedkey(seed uint32) [4]uint32 {
+	var key [16]byte
+	var words [4]uint32
+	for i := range key {
+		seed = seed * 214013 + 2531011
+		key[i] = byte(seed >> 16 & 0x7FFF)
+	}
+	for i := range words { words[i] = binary.LittleEndian.Uint32(key[4 * i:]) }
+	return words
+}
+
+// expand generates the substitution box from the cipher's key.
+func (cipher *RC5) expand() {
 	
 	// Initialize key and substitution box.
-	cipher.Key = [...]uint32 { 0xE8FEDC3C, 0x7ED654C4, 0x1AF8A616, 0xBE38D0E8 }
 	cipher.Sub[0] = 0xB7E15163
 	for i := 1; i < 26; i++ { 
 		cipher.Sub[i] = cipher.Sub[i - 1] - uint32(RC5_Q) 
@@ -68,7 +95,7 @@ func (c *RC5) Encrypt(buffer []byte) {
 		A := binary.LittleEndian.Uint32(buffer[8 * block:]) + c.Sub[0]
 		B := binary.LittleEndian.Uint32(buffer[8 * block + 4:]) + c.Sub[1]
 		
-		for i := 12; i > 0; i-- {
+		for i := 1; i <= 12; i++ {
 			A = rotl(A ^ B, B) + c.Sub[2 * i]
 			B = rotl(B ^ A, A) + c.Sub[2 * i + 1]
 		}
@@ -0,0 +1,73 @@
+package security
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// TestRC5Seed checks the key generated from a seed against MSVC's rand, which 
+// returns 41, 18467, 6334, and 26500 after srand(1).
+func TestRC5Seed(t *testing.T) {
+	if key := seedkey(1); key[0] != 0x84BE2329 { 
+		t.Fatalf("first key word %08x, want 84be2329", key[0]) 
+	}
+	
+	// The seeded cipher must round trip, and differ from the unseeded cipher.
+	seeded, unseeded := RC5 {}, RC5 {}
+	seeded.Seed(1)
+	unseeded.Init()
+	password := []byte("password\x00\x00\x00\x00\x00\x00\x00\x00")
+	buffer := append([]byte(nil), password...)
+	seeded.Encrypt(buffer)
+	encrypted := append([]byte(nil), buffer...)
+	seeded.Decrypt(buffer)
+	if !bytes.Equal(buffer, password) { t.Fatalf("decrypted %q", buffer) }
+	unseeded.Decrypt(encrypted)
+	if bytes.Equal(encrypted, password) { t.Fatalf("seed was ignored") }
+}
+
+func TestRC5RoundTrip(t *testing.T) {
+	cipher := RC5 {}
+	cipher.Init()
+	password := []byte("test\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	buffer := append([]byte(nil), password...)
+	cipher.Encrypt(buffer)
+	if bytes.Equal(buffer, password) { t.Fatalf("not encrypted") }
+	cipher.Decrypt(buffer)
+	if !bytes.Equal(buffer, password) { t.Fatalf("decrypted %q", buffer) }
+}
+
+// TestRC5KnownAnswer checks the cipher against the RC5-32/12/16 test vectors 
+// from Rivest's paper, which a round trip alone wouldn't catch (such as rounds 
+// applied in the wrong order by both Encrypt and Decrypt).
+func TestRC5KnownAnswer(t *testing.T) {
+	tests := []struct { key, plain, ciphertext string }{
+		{ "00000000000000000000000000000000", "0000000000000000", 
+			"21a5dbee154b8f6d" },
+		{ "915f4619be41b2516355a50110a9ce91", "21a5dbee154b8f6d", 
+			"f7c013ac5b2b8952" },
+		{ "783348e75aeb0f2fd7b169bb8dc16787", "f7c013ac5b2b8952", 
+			"2f42b3b70369fc92" },
+	}
+	for _, test := range tests {
+		key, _ := hex.DecodeString(test.key)
+		cipher := RC5 {}
+		for i := range cipher.Key { 
+			cipher.Key[i] = binary.LittleEndian.Uint32(key[4 * i:]) 
+		}
+		cipher.expand()
+		buffer, _ := hex.DecodeString(test.plain)
+		cipher.Encrypt(buffer)
+		if hex.EncodeToString(buffer) != test.ciphertext {
+			t.Errorf("encrypted %s as %x, want %s", test.plain, buffer, 
+				test.ciphertext)
+		}
+		cipher.Decrypt(buffer)
+		if hex.EncodeToString(buffer) != test.plain {
+			t.Errorf("decrypted %s as %x, want %s", test.ciphertext, buffer, 
+				test.plain)
+		}
+	}
+}
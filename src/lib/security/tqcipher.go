@@ -102,4 +102,36 @@ func (c *TQCipher) Encrypt(buffer []byte) {
 		buffer[i] ^= c.key1[int(byte(c.encryptcount >> 8)) + 0x100]
 		c.encryptcount++
 	}
-}
\ No newline at end of file
+}
+
+// TQClientCipher is the game client's side of the TQCipher. It isn't used by the
+// servers, but by tools which connect to a server as a client (such as the 
+// replay command). Encrypt reverses the server's Decrypt, and Decrypt reverses 
+// the server's Encrypt. Generate should be called after sending MsgConnect.
+type TQClientCipher struct {
+	TQCipher
+}
+
+// Encrypt encrypts a packet to be sent to the server.
+func (c *TQClientCipher) Encrypt(buffer []byte) {
+	for i := 0; i < len(buffer); i++ {
+		
+		buffer[i] ^= c.key[int(byte(c.decryptcount >> 8)) + 0x100]
+		buffer[i] ^= c.key[byte(c.decryptcount & 0xFF)]
+		buffer[i] = buffer[i] >> 4 | buffer[i] << 4
+		buffer[i] ^= 0xAB
+		c.decryptcount++
+	}
+}
+
+// Decrypt decrypts a packet received from the server.
+func (c *TQClientCipher) Decrypt(buffer []byte) {
+	for i := 0; i < len(buffer); i++ {
+		
+		buffer[i] ^= c.key1[int(byte(c.encryptcount >> 8)) + 0x100]
+		buffer[i] ^= c.key1[byte(c.encryptcount & 0xFF)]
+		buffer[i] = buffer[i] >> 4 | buffer[i] << 4
+		buffer[i] ^= 0xAB
+		c.encryptcount++
+	}
+}
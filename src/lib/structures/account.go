@@ -1,18 +1,65 @@
 package structures
 
-// Account is instantiated when a user logs into the account server. The structure 
-// is populated by decoding the account JSON file from the database. 
+import "time"
+
+// Account is instantiated when a user logs into the account server. The structure
+// is populated by decoding the account JSON file from the database. Statuses
+// other than ACCTSTATUS_OK are set with a reason and the identity of the
+// moderator who set them (zero for the server itself, such as a lock after failed
+// logins). If the status is temporary, StatusExpires is when the account returns
+// to ACCTSTATUS_OK. Every status change is appended to the account's History.
 type Account struct {
-	Identity  uint32
-	Username  string
-	Password  string
-	Authority uint32
+	Identity        uint32
+	Username        string
+	Password        string
+	Authority       uint32
+	Status          uint32
+	StatusExpires   time.Time    `json:",omitzero"`
+	StatusReason    string       `json:",omitempty"`
+	StatusModerator uint32       `json:",omitempty"`
+	History         []Moderation `json:",omitempty"`
+}
+
+// Moderation is an entry in an account's moderation history, recording a change
+// to the account's status.
+type Moderation struct {
+	Time      time.Time
 	Status    uint32
+	Expires   time.Time `json:",omitzero"`
+	Reason    string    `json:",omitempty"`
+	Moderator uint32
 }
-	
+
 const (
 	ACCTSTATUS_OK = 0
 	ACCTSTATUS_LOCKED = 1
 	ACCTSTATUS_BANNED = 2
 	ACCTSTATUS_LIKESUPERHELLABANNED = 3 // Matt is great.
-)
\ No newline at end of file
+)
+
+// SetStatus changes the account's status, and appends the change to the
+// account's history. A zero expiry sets the status until it's changed again.
+func (a *Account) SetStatus(status uint32, expires time.Time, reason string,
+	moderator uint32) {
+	if status == ACCTSTATUS_OK { expires = time.Time {} }
+	a.History = append(a.History, Moderation { time.Now(), status, expires, reason,
+		moderator })
+	a.Status, a.StatusExpires = status, expires
+	a.StatusReason, a.StatusModerator = reason, moderator
+	if status == ACCTSTATUS_OK { a.StatusReason, a.StatusModerator = "", 0 }
+}
+
+// Expired returns true if the account's temporary status has expired.
+func (a *Account) Expired() bool {
+	return a.Status != ACCTSTATUS_OK && !a.StatusExpires.IsZero() &&
+		!time.Now().Before(a.StatusExpires)
+}
+
+// Expire returns the account to ACCTSTATUS_OK if its temporary status has
+// expired. Returns true if the status was lifted, and the account should be
+// saved.
+func (a *Account) Expire() bool {
+	if !a.Expired() { return false }
+	a.SetStatus(ACCTSTATUS_OK, time.Time {}, "expired", 0)
+	return true
+}
@@ -0,0 +1,70 @@
+package structures
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSetStatus checks that status changes are appended to the history, and 
+// that returning to ACCTSTATUS_OK clears the reason, moderator, and expiry.
+func TestSetStatus(t *testing.T) {
+	account := new(Account)
+	expires := time.Now().Add(time.Hour)
+	account.SetStatus(ACCTSTATUS_BANNED, expires, "botting", 1000001)
+	if account.Status != ACCTSTATUS_BANNED || !account.StatusExpires.Equal(expires) ||
+		account.StatusReason != "botting" || account.StatusModerator != 1000001 {
+		t.Fatalf("banned account %+v", account)
+	}
+	account.SetStatus(ACCTSTATUS_OK, expires, "appealed", 1000002)
+	if account.Status != ACCTSTATUS_OK || !account.StatusExpires.IsZero() ||
+		account.StatusReason != "" || account.StatusModerator != 0 {
+		t.Fatalf("unbanned account %+v", account)
+	}
+	
+	// Both changes are in the history, with the reason for lifting the ban.
+	if len(account.History) != 2 { t.Fatalf("history %+v", account.History) }
+	first, second := account.History[0], account.History[1]
+	if first.Status != ACCTSTATUS_BANNED || !first.Expires.Equal(expires) ||
+		first.Reason != "botting" || first.Moderator != 1000001 {
+		t.Fatalf("first entry %+v", first)
+	}
+	if second.Status != ACCTSTATUS_OK || !second.Expires.IsZero() ||
+		second.Reason != "appealed" || second.Moderator != 1000002 {
+		t.Fatalf("second entry %+v", second)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  uint32
+		expires time.Duration
+		expired bool
+	}{
+		{ "ok", ACCTSTATUS_OK, 0, false },
+		{ "indefinite", ACCTSTATUS_BANNED, 0, false },
+		{ "temporary", ACCTSTATUS_LOCKED, time.Hour, false },
+		{ "expired", ACCTSTATUS_LOCKED, -time.Second, true },
+		{ "expired ban", ACCTSTATUS_BANNED, -time.Hour, true },
+	}
+	for _, test := range tests {
+		account := &Account { Status: test.status }
+		if test.expires != 0 { account.StatusExpires = time.Now().Add(test.expires) }
+		if account.Expired() != test.expired {
+			t.Errorf("%s: Expired() = %v", test.name, !test.expired)
+		}
+		if account.Expire() != test.expired {
+			t.Errorf("%s: Expire() = %v", test.name, !test.expired)
+		}
+		if !test.expired {
+			if account.Status != test.status || len(account.History) != 0 {
+				t.Errorf("%s: changed to %+v", test.name, account)
+			}
+			continue
+		}
+		if account.Status != ACCTSTATUS_OK || len(account.History) != 1 ||
+			account.History[0].Reason != "expired" {
+			t.Errorf("%s: expired to %+v", test.name, account)
+		}
+	}
+}
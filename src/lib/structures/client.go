@@ -3,38 +3,294 @@ package structures
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"lib/capture"
 	"lib/packets"
+	"lib/protocol"
 	"lib/security"
+	"runtime/debug"
+	"sync"
+	"time"
 )
 
+// Definitions for the client's outbound send queue. A client which falls more 
+// than SENDQUEUE_LENGTH packets behind, or which can't accept a write within 
+// SENDQUEUE_TIMEOUT, is dropped rather than blocking the server.
+const (
+	SENDQUEUE_LENGTH    = 256
+	SENDQUEUE_BATCHSIZE = 4096
+	SENDQUEUE_TIMEOUT   = 10 * time.Second
+)
+
+var ErrClientClosed = errors.New("structures.Client: client is closed")
+var ErrSendQueueFull = errors.New("structures.Client: send queue is full")
+
 // Client encapsulates the remote client's endpoint and used throughout the server 
 // to send and receive data from the client. The structure is inherited by the 
 // server projects' client structure to extend functionality for network actions.
+// Profile is the client's protocol profile, and Patch is the client's patch, 
+// which selects the layouts of packets sent to and received from the client. 
+// Both are selected from MsgConnect on the game server. Exchange is the client's
+// key exchange, while it's in progress. Seed is the password seed sent to the 
+// client in MsgEncryptCode by the account server, or zero if the client's patch 
+// doesn't use one. Capture records the client's session, if it's captured; 
+// outbound packets are recorded by the writer go routine once they've been 
+// written, and the capture is closed when the writer stops.
 type Client struct {
 	Account	   	*Account
 	Character	*Character
 	Cipher     	security.Cipher
 	Connection 	net.Conn
 	Identity   	uint32
+	Capture     *capture.Writer
+	Profile     *protocol.Profile
+	Patch       uint16
+	Exchange    *protocol.Exchange
+	Seed        uint32
+	
+	state    uint32
+	outbound chan queued
+	stopped  chan struct{}
+	lock     sync.RWMutex
+	closing  bool
+	
+	pinglock  sync.Mutex
+	pingsent  time.Time
+	pingstamp uint32
+	echostamp uint32
+	pinging   bool
+	latency   time.Duration
 }
 
-// Send an encrypted packet to the client. The encryption used is any cipher which 
-// meets the Cipher interface. A copy buffer is created to encrypt the packet 
-// without encrypting the original packet buffer.
-func (c *Client) Send(packet interface{}) {
+// NewClient creates a client for the remote connection and starts the client's
+// writer go routine. All packets sent to the client are queued for the writer, 
+// which encrypts and writes them to the connection in the order they were sent.
+// If the writer panics (such as in the client's cipher), the client is 
+// disconnected and recovered is called with the panic and its stack, so the 
+// panic doesn't bring down the server.
+func NewClient(connection net.Conn, 
+	recovered func(client *Client, value interface{}, stack []byte)) *Client {
+	c := &Client { Connection: connection }
+	c.outbound = make(chan queued, SENDQUEUE_LENGTH)
+	c.stopped = make(chan struct{})
+	go c.write(recovered)
+	return c
+}
+
+// queued is an entry in the client's send queue: either a buffer to be encrypted
+// and written, or a change to the client's cipher keys. Packets sent with Send 
+// also keep the packet without its seal, for the client's capture.
+type queued struct {
+	buffer []byte
+	packet []byte
+	rekey  *rekey
+}
+
+// rekey is a change to the client's cipher keys, made by the writer go routine 
+// once every packet queued before it has been encrypted. Done is closed after
+// the change has been made.
+type rekey struct {
+	change func() error
+	err    error
+	done   chan struct{}
+}
+
+// run makes the change to the client's cipher keys, unless the client's writes 
+// have already failed.
+func (r *rekey) run(failed bool) {
+	if failed { r.err = ErrClientClosed } else { r.err = r.change() }
+	close(r.done)
+}
+
+// Send encodes a packet and queues it to be encrypted and sent to the client. The
+// encryption used is any cipher which meets the Cipher interface. Send never 
+// blocks the caller: if the client's queue is full, the client is disconnected 
+// and an error is returned. If the client's profile is sealed, the server's seal
+// is appended to the packet. Errors other than ErrClientClosed are also logged,
+// since handlers don't check them.
+func (c *Client) Send(packet interface{}) error {
+	err := c.send(packet)
+	if err != nil && err != ErrClientClosed {
+		fmt.Printf("failed to send %T to %s: %s\n", packet, c.RemoteAddr(), err)
+	}
+	return err
+}
+
+// send encodes and queues a packet for Send.
+func (c *Client) send(value interface{}) error {
 	
-	// Create a copy of the buffer.
+	// Encode the packet into a new buffer.
 	writer := bytes.NewBuffer(nil)
-	err := packets.Write(writer, packet)
-	if err != nil { fmt.Println(err) }
+	err := packets.WritePatch(writer, value, c.Patch)
+	if err != nil { return err }
 	buffer := writer.Bytes()
 	
 	// Write the length of the packet to offset 0 (NetDragon byte ordering).
 	binary.LittleEndian.PutUint16(buffer[0:2], uint16(len(buffer)))
+	packet := buffer
+	if c.Profile != nil && c.Profile.Sealed { 
+		buffer = append(buffer, protocol.SEAL_SERVER...) 
+	}
+	return c.enqueue(queued { buffer: buffer, packet: packet })
+}
+
+// SendBytes queues raw bytes to be encrypted and sent to the client, without 
+// encoding a packet structure or writing its length. It's used for packets which 
+// don't have the packet header, such as the key exchange.
+func (c *Client) SendBytes(buffer []byte) error {
+	return c.enqueue(queued { buffer: append([]byte(nil), buffer...) })
+}
+
+// Rekey changes the client's cipher keys, such as after a key exchange. Since 
+// the client's cipher is shared with the writer go routine, the change is queued
+// for the writer, which makes it after encrypting every packet sent before Rekey
+// was called. Packets sent afterwards are encrypted with the new keys. Rekey 
+// blocks until the change has been made, so the next packet received from the 
+// client is decrypted with the new keys, and returns the change's error.
+func (c *Client) Rekey(change func() error) error {
+	r := &rekey { change: change, done: make(chan struct{}) }
+	if err := c.enqueue(queued { rekey: r }); err != nil { return err }
+	select {
+	case <-r.done: return r.err
+	case <-c.stopped: return ErrClientClosed
+	}
+}
+
+// RemoteAddr returns the address of the remote client. If the client connected 
+// through a trusted proxy, this is the client's address rather than the proxy's.
+func (c *Client) RemoteAddr() net.Addr {
+	return c.Connection.RemoteAddr()
+}
+
+// Close stops the client from accepting new packets. Packets already in the 
+// queue are written to the client before the connection is closed. The client
+// is moved to the disconnecting state.
+func (c *Client) Close() {
+	c.SetState(STATE_DISCONNECTING)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closing { 
+		c.closing = true
+		if c.outbound != nil { close(c.outbound) }
+	}
+}
+
+// Disconnect closes the client's connection immediately, discarding any packets
+// still waiting in the queue.
+func (c *Client) Disconnect() {
+	c.Close()
+	c.Connection.Close()
+}
+
+// Ping records a ping sent to the client by the server's heartbeat and returns 
+// the timestamp for the ping packet. It returns false if the client hasn't 
+// started its own heartbeat yet, since the client doesn't answer pings while it's
+// still logging in.
+func (c *Client) Ping() (uint32, bool) {
+	c.pinglock.Lock()
+	defer c.pinglock.Unlock()
+	if !c.pinging { return 0, false }
+	
+	c.pingsent = time.Now()
+	c.pingstamp = uint32(c.pingsent.UnixNano() / int64(time.Millisecond))
+	if c.pingstamp == 0 { c.pingstamp = 1 }
+	return c.pingstamp, true
+}
+
+// Pong is called when a ping is received from the client. If the ping is the 
+// client's echo of the server's last ping, the client's latency is measured. 
+// Else, the ping is part of the client's own heartbeat. Returns true if the ping
+// should be echoed back to the client; the same ping is never echoed twice.
+func (c *Client) Pong(timestamp uint32) bool {
+	c.pinglock.Lock()
+	defer c.pinglock.Unlock()
 	
-	// Encrypt and send the buffer to the client.
-	c.Cipher.Encrypt(buffer)
-	c.Connection.Write(buffer)
-}
\ No newline at end of file
+	if c.pingstamp != 0 && timestamp == c.pingstamp {
+		c.latency = time.Since(c.pingsent)
+		c.pingstamp = 0
+		return false
+	}
+	if c.pinging && timestamp == c.echostamp { return false }
+	c.pinging = true
+	c.echostamp = timestamp
+	return true
+}
+
+// Latency returns the round trip time of the last ping answered by the client.
+func (c *Client) Latency() time.Duration {
+	c.pinglock.Lock()
+	defer c.pinglock.Unlock()
+	return c.latency
+}
+
+// enqueue adds an encoded buffer to the client's send queue without blocking.
+func (c *Client) enqueue(entry queued) error {
+	c.lock.RLock()
+	if c.closing { c.lock.RUnlock(); return ErrClientClosed }
+	select {
+	case c.outbound <- entry:
+		c.lock.RUnlock()
+		return nil
+	default:
+		c.lock.RUnlock()
+		c.Disconnect()
+		return ErrSendQueueFull
+	}
+}
+
+// write is the client's writer go routine. It takes buffers from the queue, 
+// batches any other buffers already waiting behind it into a single write, and 
+// encrypts the batch in order. Since the ciphers are stream ciphers, encrypting 
+// a batch is the same as encrypting each packet on its own. Batches end at a 
+// change to the cipher's keys, which is made once the batch has been written. 
+// Packets in the batch are recorded to the client's capture once the batch has 
+// been written. After a failed write, the connection is closed and the remaining
+// queue is discarded.
+func (c *Client) write(recovered func(*Client, interface{}, []byte)) {
+	defer close(c.stopped)
+	defer func() { if c.Capture != nil { c.Capture.Close() } }()
+	defer func() {
+		if r := recover(); r != nil {
+			c.Disconnect()
+			if recovered != nil { recovered(c, r, debug.Stack()) }
+		}
+	}()
+	batch := make([]byte, 0, SENDQUEUE_BATCHSIZE)
+	var captured [][]byte
+	failed := false
+	for entry := range c.outbound {
+		if entry.rekey != nil { entry.rekey.run(failed); continue }
+		if failed { continue }
+		batch = append(batch[:0], entry.buffer...)
+		captured = append(captured[:0], entry.packet)
+		
+		// Batch packets which are already waiting in the queue.
+		var held *rekey
+		pending: for len(batch) < SENDQUEUE_BATCHSIZE {
+			select {
+			case next, ok := <-c.outbound:
+				if !ok { break pending }
+				if next.rekey != nil { held = next.rekey; break pending }
+				batch = append(batch, next.buffer...)
+				captured = append(captured, next.packet)
+			default: break pending
+			}
+		}
+		
+		// Encrypt and send the batch to the client.
+		c.Cipher.Encrypt(batch)
+		c.Connection.SetWriteDeadline(time.Now().Add(SENDQUEUE_TIMEOUT))
+		if _, err := c.Connection.Write(batch); err != nil {
+			c.Connection.Close()
+			failed = true
+		} else if c.Capture != nil {
+			for _, packet := range captured {
+				if packet != nil { c.Capture.Record(capture.OUTBOUND, packet) }
+			}
+		}
+		if held != nil { held.run(failed) }
+	}
+	c.Connection.Close()
+}
@@ -0,0 +1,131 @@
+package structures
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"lib/capture"
+	"lib/packets"
+	"lib/protocol"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// xorcipher adds its key to each byte, so the key a buffer was encrypted with
+// can be read back from the buffer.
+type xorcipher struct { key byte }
+
+func (x *xorcipher) Init() {}
+func (x *xorcipher) Generate(token, identity uint32) { x.key = byte(token) }
+func (x *xorcipher) Decrypt(buffer []byte) {}
+func (x *xorcipher) Encrypt(buffer []byte) {
+	for i := range buffer { buffer[i] ^= x.key }
+}
+
+// TestRekey checks that packets sent before a rekey are encrypted with the old
+// key, and packets sent after it with the new key.
+func TestRekey(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	cipher := &xorcipher { key: 0x10 }
+	client := NewClient(local, nil)
+	client.Cipher = cipher
+	defer client.Disconnect()
+	
+	// The pipe doesn't buffer, so the writer is blocked on the first packet until
+	// it's read below, while the rekey waits behind it in the queue.
+	client.SendBytes([]byte { 1, 2 })
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Rekey(func() error { cipher.Generate(0x20, 0); return nil })
+	}()
+	buffer := make([]byte, 2)
+	if _, err := io.ReadFull(remote, buffer); err != nil { t.Fatal(err) }
+	if !bytes.Equal(buffer, []byte { 0x11, 0x12 }) {
+		t.Fatalf("before rekey: got % x", buffer)
+	}
+	if err := <-done; err != nil { t.Fatal(err) }
+	
+	client.SendBytes([]byte { 3, 4 })
+	if _, err := io.ReadFull(remote, buffer); err != nil { t.Fatal(err) }
+	if !bytes.Equal(buffer, []byte { 0x23, 0x24 }) {
+		t.Fatalf("after rekey: got % x", buffer)
+	}
+}
+
+// TestRekeyError checks that the change's error is returned, and that a client
+// which has been closed can't be rekeyed.
+func TestRekeyError(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	client := NewClient(local, nil)
+	client.Cipher = &xorcipher {}
+	
+	failed := errors.New("failed")
+	if err := client.Rekey(func() error { return failed }); err != failed {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+	client.Disconnect()
+	if err := client.Rekey(func() error { return nil }); err != ErrClientClosed {
+		t.Fatalf("closed client: got %v, want %v", err, ErrClientClosed)
+	}
+}
+
+// TestWriterPanic checks that a panic in the writer disconnects the client and
+// is passed to the recovered function, and that a rekey waiting behind it fails.
+func TestWriterPanic(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	recovered := make(chan interface{}, 1)
+	client := NewClient(local, func(c *Client, value interface{}, stack []byte) {
+		recovered <- value
+	})
+	client.Cipher = &xorcipher {}
+	client.Rekey(func() error { client.Cipher = nil; return nil })
+	client.SendBytes([]byte { 1 })
+	if value := <-recovered; value == nil { t.Fatal("panic wasn't recovered") }
+	if err := client.Rekey(func() error { return nil }); err != ErrClientClosed {
+		t.Fatalf("got %v, want %v", err, ErrClientClosed)
+	}
+}
+
+// TestCapture checks that packets are recorded once they've been written, 
+// without the server's seal, and that the capture is closed with the writer.
+func TestCapture(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	directory := t.TempDir()
+	writer, err := capture.Create(directory, "127.0.0.1:9958")
+	if err != nil { t.Fatal(err) }
+	client := NewClient(local, nil)
+	client.Cipher = &xorcipher {}
+	client.Capture = writer
+	client.Profile = protocol.Lookup("blowfish")
+	
+	if err := client.Send(packets.NewMsgItem()); err != nil { t.Fatal(err) }
+	buffer := make([]byte, 20 + len(protocol.SEAL_SERVER))
+	if _, err := io.ReadFull(remote, buffer); err != nil { t.Fatal(err) }
+	client.SendBytes([]byte { 1, 2 })
+	io.ReadFull(remote, make([]byte, 2))
+	client.Disconnect()
+	<-client.stopped
+	
+	files, _ := filepath.Glob(filepath.Join(directory, "*"))
+	if len(files) != 1 { t.Fatalf("capture directory has %v", files) }
+	file, err := os.Open(files[0])
+	if err != nil { t.Fatal(err) }
+	defer file.Close()
+	reader, err := capture.NewReader(file)
+	if err != nil { t.Fatal(err) }
+	record, err := reader.Next()
+	if err != nil { t.Fatal(err) }
+	if record.Direction != capture.OUTBOUND || 
+		!bytes.Equal(record.Data, buffer[:20]) {
+		t.Fatalf("got record %+v, want % x", record, buffer[:20])
+	}
+	if record, err := reader.Next(); err != io.EOF { 
+		t.Fatalf("got record %+v, %v, want %v", record, err, io.EOF) 
+	}
+}
@@ -2,48 +2,157 @@ package structures
 
 import (
 	"fmt"
-	"net"
+	"lib/backend"
+	"sync"
 	"time"
 )
 
 // GameServer is used during the account to game server transfer. The client 
 // specifies which game server to connect to in the MsgAccount packet. Then, the 
 // account server sends a MsgConnectEx to forward the client to the correct game 
-// server.
+// server. Secret is the shared secret for the game server's backend channel, 
+// which must match the game server's BackendSecret. The game server's last 
+// status report is kept until the connection is lost. The backend connection
+// and status are guarded by the game server's lock, since they're read by every
+// login while Connect replaces them.
 type GameServer struct {
 	Name       string
 	Host       string
 	Port       uint32
 	Backend    string
-	Connection net.Conn
+	Secret     string
+	connection *backend.Conn
+	status     *ServerStatus
+	closed     chan struct{}
+	lock       sync.Mutex
+}
+
+// Connection returns the game server's backend connection, or nil if the game
+// server is offline.
+func (g *GameServer) Connection() *backend.Conn {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.connection
+}
+
+// Status returns the game server's last status report, or nil if the game 
+// server hasn't reported its status since connecting.
+func (g *GameServer) Status() *ServerStatus {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.status
+}
+
+// setStatus replaces the game server's last status report, unless the game 
+// server has been closed.
+func (g *GameServer) setStatus(status *ServerStatus) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.isclosed() { g.status = status }
+}
+
+// setConnection replaces the game server's backend connection and clears its
+// status. Returns false if the game server has been closed, in which case the
+// connection isn't kept.
+func (g *GameServer) setConnection(connection *backend.Conn) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.isclosed() { return false }
+	g.connection = connection
+	g.status = nil
+	return true
+}
+
+// Close stops the game server's Connect loop and closes its backend connection.
+// It's called when the game server is removed from the account server, and may
+// be called more than once.
+func (g *GameServer) Close() {
+	g.lock.Lock()
+	if g.isclosed() { g.lock.Unlock(); return }
+	close(g.done())
+	connection := g.connection
+	g.connection = nil
+	g.status = nil
+	g.lock.Unlock()
+	if connection != nil { connection.Close() }
+}
+
+// done returns the channel which is closed once the game server is closed. Must
+// be called with the lock held.
+func (g *GameServer) done() chan struct{} {
+	if g.closed == nil { g.closed = make(chan struct{}) }
+	return g.closed
+}
+
+// isclosed returns true if the game server has been closed. Must be called with
+// the lock held.
+func (g *GameServer) isclosed() bool {
+	select {
+	case <-g.done(): return true
+	default: return false
+	}
+}
+
+// wait waits for the delay, and returns false if the game server was closed in
+// the meantime.
+func (g *GameServer) wait(delay time.Duration) bool {
+	g.lock.Lock()
+	done := g.done()
+	g.lock.Unlock()
+	select {
+	case <-done: return false
+	case <-time.After(delay): return true
+	}
 }
 
 // Connect establishes a connection from the account server to the specified game 
-// server. The game server must white list the account server in order to establish
-// the connection.
+// server. The game server must white list the account server, and both servers 
+// must authenticate each other with the shared secret, in order to establish the
+// connection. Connect runs until the game server is closed.
 func (g *GameServer) Connect() {
 	for { // Reattempt after failure.
+		var connection *backend.Conn
 		for { // While the connection fails, reattempt once a second.
-			connection, err := net.Dial("tcp", fmt.Sprintf("%s", g.Backend))
+			var err error
+			connection, err = backend.Dial(g.Backend, []byte(g.Secret))
 			if err == nil { 
-				g.Connection = connection
 				break
-			} else { time.Sleep(time.Second) }
+			} else if backend.CheckSecret([]byte(g.Secret)) != nil {
+				fmt.Printf("%s: %s\n", g.Name, err)
+				return
+			} else if err == backend.ErrAuthentication {
+				fmt.Printf("%s failed backend authentication\n", g.Name)
+			}
+			if !g.wait(time.Second) { return }
 		}
+		if !g.setConnection(connection) { connection.Close(); return }
 		fmt.Printf("Connection established with %s\n", g.Name)
 
 		for {
 			// Attempt to read from the connection. Once this fails, the connection
 			// has been broken and will need to be re-established.
-			buffer := make([]byte, 1024)
-			length, err := g.Connection.Read(buffer)
-			if length > 0 && err == nil {
-				
-				// handle packet
-				
-			} else { break }
+			envelope, err := connection.Receive()
+			if err != nil { break }
+			if envelope.Type != backend.MESSAGE_STATUS { continue }
+			status := new(ServerStatus)
+			if err := envelope.Decode(status); err != nil { 
+				fmt.Println(err)
+				continue
+			}
+			status.received = time.Now()
+			if previous := g.Status(); previous == nil || 
+				previous.Accepting != status.Accepting {
+				if status.Accepting {
+					fmt.Printf("%s is accepting logins\n", g.Name)
+				} else { fmt.Printf("%s is in maintenance\n", g.Name) }
+			}
+			g.setStatus(status)
+		}
+		connection.Close()
+		if !g.setConnection(nil) {
+			fmt.Printf("Connection closed with %s\n", g.Name)
+			return
 		}
 		fmt.Printf("Connection lost with %s\n", g.Name)
-		g.Connection = nil
 	}
 }
@@ -0,0 +1,40 @@
+package structures
+
+import "sync/atomic"
+
+// ClientState is the client's position in the login sequence. Each packet type 
+// is only valid in certain states; packets received in any other state are 
+// rejected by the server before they reach a handler.
+type ClientState uint32
+const (
+	STATE_CONNECTED     ClientState = iota // Connected, waiting for login.
+	STATE_KEYED                            // Cipher keys have been exchanged.
+	STATE_CREATING                         // Awaiting character creation.
+	STATE_LOGGINGIN                        // Character is being sent.
+	STATE_INWORLD                          // Character is in the game world.
+	STATE_DISCONNECTING                    // Connection is being closed.
+)
+
+var statenames = [...]string { "connected", "keyed", "awaiting character creation",
+	"logging in", "in world", "disconnecting" }
+
+func (s ClientState) String() string {
+	if int(s) < len(statenames) { return statenames[s] }
+	return "unknown"
+}
+
+// State returns the client's current state. It's safe to call from any go 
+// routine, such as the client's heartbeat.
+func (c *Client) State() ClientState {
+	return ClientState(atomic.LoadUint32(&c.state))
+}
+
+// SetState moves the client to a new state. A disconnecting client stays in the
+// disconnecting state.
+func (c *Client) SetState(state ClientState) {
+	for {
+		current := atomic.LoadUint32(&c.state)
+		if ClientState(current) == STATE_DISCONNECTING { return }
+		if atomic.CompareAndSwapUint32(&c.state, current, uint32(state)) { return }
+	}
+}
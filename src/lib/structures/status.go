@@ -0,0 +1,38 @@
+package structures
+
+import "time"
+
+// ServerStatus is sent periodically by a game server to the account server over
+// the backend channel, and again whenever the game server's status changes. The 
+// account server uses the game server's last status to turn players away before
+// transferring them: players are told the server is down while it isn't 
+// accepting logins (such as during maintenance), and to login later while it's 
+// full. Capacity is the most players the game server allows online at once; zero
+// allows any number of players. Interval is the time between the game server's 
+// reports, which the account server uses to tell when reports have stopped.
+type ServerStatus struct {
+	Online    int
+	Capacity  int
+	Accepting bool
+	Version   string
+	Interval  time.Duration
+	received  time.Time
+}
+
+// STATUS_MISSED is the number of status reports a game server may miss before 
+// its last status is stale.
+const STATUS_MISSED = 3
+
+// Full returns true if the game server has reached its capacity.
+func (s *ServerStatus) Full() bool {
+	return s.Capacity > 0 && s.Online >= s.Capacity
+}
+
+// Stale returns true if the game server has missed STATUS_MISSED reports since 
+// the status was received, such as when the game server has stopped responding
+// without closing its backend connection. Statuses from game servers which don't
+// report their interval are never stale.
+func (s *ServerStatus) Stale() bool {
+	return s.Interval > 0 && 
+		time.Since(s.received) > STATUS_MISSED * s.Interval
+}
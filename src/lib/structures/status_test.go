@@ -0,0 +1,26 @@
+package structures
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerStatusFull(t *testing.T) {
+	if (&ServerStatus { Online: 100 }).Full() { t.Error("full without capacity") }
+	if (&ServerStatus { Online: 99, Capacity: 100 }).Full() { t.Error("full early") }
+	if !(&ServerStatus { Online: 100, Capacity: 100 }).Full() { 
+		t.Error("not full at capacity") 
+	}
+}
+
+// TestServerStatusStale checks that a status is stale once STATUS_MISSED reports
+// have been missed, and never for game servers which don't send an interval.
+func TestServerStatusStale(t *testing.T) {
+	status := &ServerStatus { Interval: 10 * time.Second }
+	status.received = time.Now().Add(-29 * time.Second)
+	if status.Stale() { t.Error("stale before missing three reports") }
+	status.received = time.Now().Add(-31 * time.Second)
+	if !status.Stale() { t.Error("not stale after missing three reports") }
+	status.Interval = 0
+	if status.Stale() { t.Error("stale without an interval") }
+}
@@ -7,9 +7,18 @@ import "time"
 // session token and account id. If done incorrectly, this opens vulnerability for 
 // session hijacking or, even worse, bypassing authentication for any user. 
 // GoConquer makes attempts to avoid this by sending this structure over a backend
-// channel to the other server.
+// channel to the other server. Token is a random one-time token, which the 
+// client must send back in MsgConnect within the game server's transfer window.
+// Received is set by the game server when the transfer arrives, so the window 
+// doesn't depend on the servers' clocks agreeing.
 type Transfer struct {
 	Account   Account
 	IPAddress string
 	Requested time.Time
+	Token     uint32
+	Received  time.Time
 }
+
+// TRANSFER_WINDOW is the default time a client has to connect to the game server
+// after its transfer arrives.
+const TRANSFER_WINDOW = 30 * time.Second
@@ -34,6 +34,9 @@ func (sm *SafeMap) Contains(key interface{}) bool {
 }
 
 func (sm *SafeMap) Count() int {
+	sm.RLock()
+	defer sm.RUnlock()
+	
 	return len(sm.Elements)
 }
 
@@ -46,6 +49,24 @@ func (sm *SafeMap) Get(key interface{}) interface{} {
 	return nil
 }
 
+func (sm *SafeMap) Keys() []interface{} {
+	sm.RLock()
+	defer sm.RUnlock()
+	
+	result := make([]interface{}, 0, len(sm.Elements))
+	for key := range sm.Elements { result = append(result, key) }
+	return result
+}
+
+func (sm *SafeMap) Values() []interface{} {
+	sm.RLock()
+	defer sm.RUnlock()
+	
+	result := make([]interface{}, 0, len(sm.Elements))
+	for _, value := range sm.Elements { result = append(result, value) }
+	return result
+}
+
 func (sm *SafeMap) Remove(key interface{}) interface{} {
 	sm.Lock()
 	defer sm.Unlock()
@@ -57,3 +78,32 @@ func (sm *SafeMap) Remove(key interface{}) interface{} {
 	}
 	return nil
 }
+
+func (sm *SafeMap) RemoveValue(key interface{}, value interface{}) bool {
+	sm.Lock()
+	defer sm.Unlock()
+	
+	result, exists := sm.Elements[key]
+	if exists && result == value {
+		delete(sm.Elements, key)
+		return true
+	}
+	return false
+}
+
+// RemoveIf removes every element for which the condition returns true, and 
+// returns the number of elements removed. The map is locked while the condition 
+// is called, so it must not call back into the map.
+func (sm *SafeMap) RemoveIf(condition func(key, value interface{}) bool) int {
+	sm.Lock()
+	defer sm.Unlock()
+	
+	removed := 0
+	for key, value := range sm.Elements {
+		if condition(key, value) { 
+			delete(sm.Elements, key)
+			removed++
+		}
+	}
+	return removed
+}
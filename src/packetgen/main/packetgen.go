@@ -0,0 +1,388 @@
+// Packetgen generates typed MarshalBinary and UnmarshalBinary methods for the 
+// packet structures in a package, so packets.Read and packets.Write don't need 
+// reflection for every field of every packet. The generated methods produce the
+// same bytes as the reflection codec. It's run by go generate from lib/packets:
+//
+//	//go:generate go run packetgen/main -output marshal_gen.go
+//
+// Every struct type in the package which embeds PacketHeader as its first field
+// is generated. Fields are encoded in declaration order according to their 
+// underlying type and `len`, `count`, `skip`, `prefix`, and `since` tags, the 
+// same way the reflection codec encodes them. MarshalPatch and UnmarshalPatch 
+// encode the layout of a client patch, and MarshalBinary and UnmarshalBinary the
+// layout of the oldest patch.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// basics maps the basic types supported by the codec to the encoder and decoder
+// method used for them, and the size of the type in bytes.
+var basics = map[string]struct { method string; size int } {
+	"bool":   { "bool", 1 },
+	"byte":   { "uint8", 1 },
+	"uint8":  { "uint8", 1 },
+	"int8":   { "uint8", 1 },
+	"uint16": { "uint16", 2 },
+	"int16":  { "uint16", 2 },
+	"uint32": { "uint32", 4 },
+	"int32":  { "uint32", 4 },
+	"uint64": { "uint64", 8 },
+	"int64":  { "uint64", 8 },
+}
+
+// MAX_DEPTH is the maximum nesting of collections, matching lib/packets.
+const MAX_DEPTH = 8
+
+// generator holds the type declarations of the package and the output buffers 
+// for the generated code.
+type generator struct {
+	types   map[string]ast.Expr
+	buffer  bytes.Buffer
+	indexes int
+}
+
+func main() {
+	output := flag.String("output", "marshal_gen.go", "name of the generated file")
+	flag.Parse()
+	
+	// Parse the package in the current directory, skipping generated and test 
+	// files, and collect its type declarations.
+	fileset := token.NewFileSet()
+	packages, err := parser.ParseDir(fileset, ".", func(info os.FileInfo) bool {
+		name := info.Name()
+		return name != *output && !strings.HasSuffix(name, "_test.go")
+	}, 0)
+	if err != nil { fmt.Println(err); os.Exit(1) }
+	if len(packages) != 1 { fmt.Println("packetgen: expected one package"); os.Exit(1) }
+	
+	g := &generator { types: make(map[string]ast.Expr) }
+	var name string
+	var structs []string
+	for n, p := range packages {
+		name = n
+		for _, file := range p.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE { continue }
+				for _, spec := range gen.Specs {
+					t := spec.(*ast.TypeSpec)
+					g.types[t.Name.Name] = t.Type
+					if isPacket(t.Type) { structs = append(structs, t.Name.Name) }
+				}
+			}
+		}
+	}
+	sort.Strings(structs)
+	
+	// Generate the methods for each packet structure.
+	fmt.Fprintf(&g.buffer, "// Code generated by packetgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&g.buffer, "package %s\n", name)
+	for _, s := range structs {
+		if err := g.generate(s); err != nil { 
+			fmt.Printf("packetgen: %s: %s\n", s, err)
+			os.Exit(1) 
+		}
+	}
+	source, err := format.Source(g.buffer.Bytes())
+	if err != nil { fmt.Println(err); os.Exit(1) }
+	err = os.WriteFile(filepath.Join(".", *output), source, 0664)
+	if err != nil { fmt.Println(err); os.Exit(1) }
+}
+
+// isPacket returns true if the type is a struct with PacketHeader embedded as
+// its first field.
+func isPacket(t ast.Expr) bool {
+	s, ok := t.(*ast.StructType)
+	if !ok || len(s.Fields.List) == 0 { return false }
+	first := s.Fields.List[0]
+	ident, ok := first.Type.(*ast.Ident)
+	return ok && len(first.Names) == 0 && ident.Name == "PacketHeader"
+}
+
+// generate writes the MarshalBinary and UnmarshalBinary methods for a structure.
+func (g *generator) generate(name string) error {
+	s := g.types[name].(*ast.StructType)
+	
+	var marshal, unmarshal bytes.Buffer
+	g.indexes = 0
+	err := g.fields(&marshal, &unmarshal, "p", name, 0, s)
+	if err != nil { return err }
+	size, err := g.minsize(s, "")
+	if err != nil { return err }
+	
+	fmt.Fprintf(&g.buffer, "\n// MarshalBinary encodes %s using the oldest patch's layout.\n", 
+		name)
+	fmt.Fprintf(&g.buffer, "func (p *%s) MarshalBinary() ([]byte, error) {\n", name)
+	fmt.Fprintf(&g.buffer, "return p.MarshalPatch(0)\n}\n")
+	fmt.Fprintf(&g.buffer, "\n// UnmarshalBinary decodes %s using the oldest patch's layout.\n", 
+		name)
+	fmt.Fprintf(&g.buffer, "func (p *%s) UnmarshalBinary(data []byte) error {\n", name)
+	fmt.Fprintf(&g.buffer, "return p.UnmarshalPatch(data, 0)\n}\n")
+	fmt.Fprintf(&g.buffer, "\n// MarshalPatch encodes %s using a client patch's layout.\n", name)
+	fmt.Fprintf(&g.buffer, "func (p *%s) MarshalPatch(patch uint16) ([]byte, error) {\n", 
+		name)
+	fmt.Fprintf(&g.buffer, "e := encoder{data: make([]byte, 0, %d)}\n", size)
+	fmt.Fprintf(&g.buffer, "%sreturn e.data, e.err\n}\n", marshal.String())
+	fmt.Fprintf(&g.buffer, "\n// UnmarshalPatch decodes %s using a client patch's layout.\n", 
+		name)
+	fmt.Fprintf(&g.buffer, "func (p *%s) UnmarshalPatch(data []byte, patch uint16) error {\n", 
+		name)
+	fmt.Fprintf(&g.buffer, "d := decoder{data: data}\n%sreturn d.err\n}\n", 
+		unmarshal.String())
+	return nil
+}
+
+// fields writes the code for each field of a structure, in declaration order. 
+// Path is the Go expression for the structure, and name is the field name used in
+// errors, with "[]" for each collection index. Skip tags are applied once per 
+// field, before the field's value.
+func (g *generator) fields(m, u *bytes.Buffer, path, name string, depth int, 
+	s *ast.StructType) error {
+	
+	for _, field := range s.Fields.List {
+		tag := structtag(field)
+		names := []string {}
+		for _, n := range field.Names { names = append(names, n.Name) }
+		if len(names) == 0 { names = append(names, exprname(field.Type)) }
+		for _, n := range names {
+			if since := tag.Get("since"); since != "" { // Patch dependent field.
+				if _, err := strconv.ParseUint(since, 10, 16); err != nil { 
+					return fmt.Errorf("%s: %s", n, err) 
+				}
+				fmt.Fprintf(m, "if patch >= %s {\n", since)
+				fmt.Fprintf(u, "if patch >= %s {\n", since)
+			}
+			if skip := tag.Get("skip"); skip != "" {
+				length, err := strconv.Atoi(skip)
+				if err != nil { return fmt.Errorf("%s: %s", n, err) }
+				fmt.Fprintf(m, "e.zero(%d)\n", length)
+				fmt.Fprintf(u, "d.skip(%q, %d)\n", name + "." + n, length)
+			}
+			err := g.field(m, u, path + "." + n, name + "." + n, depth, 
+				!ast.IsExported(n), field.Type, tag)
+			if err != nil { return fmt.Errorf("%s: %s", n, err) }
+			if tag.Get("since") != "" {
+				fmt.Fprintf(m, "}\n")
+				fmt.Fprintf(u, "}\n")
+			}
+		}
+	}
+	return nil
+}
+
+// field writes the code for a single value. Blank fields are written as zeros 
+// and skipped when reading, like the reflection codec.
+func (g *generator) field(m, u *bytes.Buffer, path, name string, depth int, 
+	blank bool, t ast.Expr, tag reflect.StructTag) error {
+	
+	declared := exprname(t)
+	underlying, err := g.resolve(t)
+	if err != nil { return err }
+	if blank {
+		size, err := g.blanksize(underlying)
+		if err != nil { return err }
+		fmt.Fprintf(m, "e.zero(%d)\n", size)
+		fmt.Fprintf(u, "d.skip(%q, %d)\n", name, size)
+		return nil
+	}
+	
+	switch ut := underlying.(type) {
+	case *ast.StructType:
+		if prefix := tag.Get("prefix"); prefix != "" { // Length-prefixed record.
+			w, err := width(prefix)
+			if err != nil { return err }
+			record := fmt.Sprintf("r%d", g.next())
+			fmt.Fprintf(m, "%s := e.begin(%d)\n", record, w)
+			fmt.Fprintf(u, "%s := d.begin(%q, %d)\n", record, name, w)
+			if err := g.fields(m, u, path, name, depth, ut); err != nil { return err }
+			fmt.Fprintf(m, "e.end(%q, %d, %s)\n", name, w, record)
+			fmt.Fprintf(u, "d.end(%s)\n", record)
+			return nil
+		}
+		return g.fields(m, u, path, name, depth, ut)
+		
+	case *ast.ArrayType:
+		if depth >= MAX_DEPTH { return fmt.Errorf("nested too deeply") }
+		index := fmt.Sprintf("i%d", g.next())
+		if ut.Len == nil { // Slice with a count prefix.
+			w, err := width(tag.Get("count"))
+			if err != nil { return err }
+			minimum, err := g.minsize(ut.Elt, tag)
+			if err != nil { return err }
+			fmt.Fprintf(m, "e.count(%q, %d, len(%s))\n", name, w, path)
+			fmt.Fprintf(u, "%s = make(%s, d.count(%q, %d, %d))\n", 
+				path, declared, name, w, minimum)
+		}
+		fmt.Fprintf(m, "for %s := range %s {\ne.indexes[%d] = %s\n", 
+			index, path, depth, index)
+		fmt.Fprintf(u, "for %s := range %s {\nd.indexes[%d] = %s\n", 
+			index, path, depth, index)
+		err := g.field(m, u, path + "[" + index + "]", name + "[]", depth + 1, false, 
+			ut.Elt, tag)
+		if err != nil { return err }
+		fmt.Fprintf(m, "}\n")
+		fmt.Fprintf(u, "}\n")
+		return nil
+		
+	case *ast.Ident:
+		if ut.Name == "string" {
+			value, decoded := path, fmt.Sprintf("d.dynamic(%q)", name)
+			if length := tag.Get("len"); length != "" { // Fixed string.
+				if _, err := strconv.Atoi(length); err != nil { return err }
+				decoded = fmt.Sprintf("d.fixed(%q, %s)", name, length)
+			}
+			if declared != "string" {
+				value = "string(" + path + ")"
+				decoded = declared + "(" + decoded + ")"
+			}
+			if length := tag.Get("len"); length != "" {
+				fmt.Fprintf(m, "e.fixed(%s, %s)\n", value, length)
+			} else { fmt.Fprintf(m, "e.dynamic(%q, %s)\n", name, value) }
+			fmt.Fprintf(u, "%s = %s\n", path, decoded)
+			return nil
+		}
+		basic := basics[ut.Name]
+		value, decoded := path, fmt.Sprintf("d.%s(%q)", basic.method, name)
+		if declared != basic.method && declared != "byte" {
+			value = basic.method + "(" + path + ")"
+			decoded = declared + "(" + decoded + ")"
+		}
+		fmt.Fprintf(m, "e.%s(%s)\n", basic.method, value)
+		fmt.Fprintf(u, "%s = %s\n", path, decoded)
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", declared)
+}
+
+// minsize returns the minimum number of bytes a value of the type is encoded in,
+// matching minsize in lib/packets. Fields tagged with since aren't counted. It 
+// bounds the count of collections when decoding, and sizes the buffer when 
+// encoding.
+func (g *generator) minsize(t ast.Expr, tag reflect.StructTag) (int, error) {
+	underlying, err := g.resolve(t)
+	if err != nil { return 0, err }
+	switch ut := underlying.(type) {
+	case *ast.StructType:
+		size := 0
+		if prefix := tag.Get("prefix"); prefix != "" {
+			w, err := width(prefix)
+			if err != nil { return 0, err }
+			size += w
+		}
+		for _, field := range ut.Fields.List {
+			ftag := structtag(field)
+			if ftag.Get("since") != "" { continue }
+			fsize, err := g.minsize(field.Type, ftag)
+			if err != nil { return 0, err }
+			skip, _ := strconv.Atoi(ftag.Get("skip"))
+			count := len(field.Names)
+			if count == 0 { count = 1 }
+			size += count * (skip + fsize)
+		}
+		return size, nil
+		
+	case *ast.ArrayType:
+		if ut.Len == nil { return width(tag.Get("count")) }
+		length, err := arraylen(ut)
+		if err != nil { return 0, err }
+		size, err := g.minsize(ut.Elt, tag)
+		return length * size, err
+		
+	case *ast.Ident:
+		if ut.Name == "string" {
+			if length, err := strconv.Atoi(tag.Get("len")); err == nil { 
+				return length, nil 
+			}
+			return 1, nil
+		}
+		return basics[ut.Name].size, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s", exprname(t))
+}
+
+// blanksize returns the size of a blank field's padding, which must be a basic 
+// type or an array of them.
+func (g *generator) blanksize(t ast.Expr) (int, error) {
+	switch ut := t.(type) {
+	case *ast.Ident:
+		if basic, exists := basics[ut.Name]; exists { return basic.size, nil }
+	case *ast.ArrayType:
+		if ut.Len == nil { break }
+		length, err := arraylen(ut)
+		if err != nil { return 0, err }
+		elt, err := g.resolve(ut.Elt)
+		if err != nil { return 0, err }
+		size, err := g.blanksize(elt)
+		return length * size, err
+	}
+	return 0, fmt.Errorf("unsupported blank type %s", exprname(t))
+}
+
+// next returns a new number for naming loop and record variables.
+func (g *generator) next() int {
+	g.indexes++
+	return g.indexes
+}
+
+// resolve returns the underlying type expression of a type, following the 
+// package's named types until a basic type, array, or structure is found.
+func (g *generator) resolve(t ast.Expr) (ast.Expr, error) {
+	for {
+		ident, ok := t.(*ast.Ident)
+		if !ok { return t, nil }
+		if _, basic := basics[ident.Name]; basic || ident.Name == "string" { 
+			return ident, nil 
+		}
+		next, exists := g.types[ident.Name]
+		if !exists { return nil, fmt.Errorf("unknown type %s", ident.Name) }
+		t = next
+	}
+}
+
+// structtag returns the tag of a structure field.
+func structtag(field *ast.Field) reflect.StructTag {
+	if field.Tag == nil { return "" }
+	value, _ := strconv.Unquote(field.Tag.Value)
+	return reflect.StructTag(value)
+}
+
+// arraylen returns the length of a fixed length array, which must be an integer
+// literal.
+func arraylen(t *ast.ArrayType) (int, error) {
+	if lit, ok := t.Len.(*ast.BasicLit); ok && lit.Kind == token.INT {
+		return strconv.Atoi(lit.Value)
+	}
+	return 0, fmt.Errorf("array length %s must be a literal", exprname(t.Len))
+}
+
+// width returns the width in bytes of a count or prefix tag, matching width in
+// lib/packets.
+func width(tag string) (int, error) {
+	switch tag {
+	case "", "uint8", "byte": return 1, nil
+	case "uint16": return 2, nil
+	case "uint32": return 4, nil
+	}
+	return 0, fmt.Errorf("invalid width %s", tag)
+}
+
+// exprname returns the source code of a type expression.
+func exprname(t ast.Expr) string {
+	var buffer bytes.Buffer
+	format.Node(&buffer, token.NewFileSet(), t)
+	return buffer.String()
+}
@@ -0,0 +1,232 @@
+// Replay feeds the inbound packets of a session capture back into a running 
+// account or game server, so a session which crashed a client or server can be 
+// reproduced (and turned into a regression test). Usage:
+//
+//	conquer-replay [-host 127.0.0.1:5816] [-mode game] [-profile auto] [-speed 1]
+//		session.cap
+//
+// Packets are encrypted with the client's side of the cipher, and are paced by 
+// their recorded timestamps divided by the speed (a speed of zero sends them as
+// fast as possible). The account server always uses the TQCipher. In game mode, 
+// the cipher and key exchange are taken from the protocol profile: by default, 
+// the profile is selected from the version in the captured MsgConnect. For the 
+// TQCipher, the cipher is re-keyed after the MsgConnect packet; for Blowfish, 
+// the key exchange is made with the server before any packets are replayed, and
+// packets are sealed. Packets sent by the server are decrypted and summarized. 
+// In account mode, the password in MsgAccount was encrypted with the seed from 
+// the captured MsgEncryptCode (if the server sent one), so it's re-encrypted 
+// with the seed sent by the replayed server. 
+// Note that the game server only accepts a replayed MsgConnect if the account 
+// server has authenticated the account for the replaying address, and its 
+// listener must use a profile compatible with the replayed one.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"lib/capture"
+	"lib/packets"
+	"lib/protocol"
+	"lib/security"
+	"net"
+	"os"
+	"time"
+)
+
+// ACCOUNT_PASSWORD is the offset of the password in MsgAccount.
+const ACCOUNT_PASSWORD = 20
+
+func main() {
+	host := flag.String("host", "127.0.0.1:5816", "address of the server")
+	mode := flag.String("mode", "game", "server type: account or game")
+	name := flag.String("profile", "auto", 
+		"game protocol profile, or auto to select it from MsgConnect")
+	speed := flag.Float64("speed", 1, "replay speed; zero sends without delay")
+	flag.Parse()
+	if flag.NArg() != 1 || (*mode != "account" && *mode != "game") {
+		fmt.Println("usage: conquer-replay [flags] session.cap")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	
+	// Read the capture file. The records are read before connecting, since the 
+	// profile may be selected from a later record.
+	file, err := os.Open(flag.Arg(0))
+	if err != nil { fmt.Println(err); os.Exit(1) }
+	defer file.Close()
+	reader, err := capture.NewReader(file)
+	if err != nil { fmt.Println(err); os.Exit(1) }
+	fmt.Printf("Replaying session from %s, captured %s\n", reader.Address, 
+		reader.Started.Format(time.RFC1123))
+	var records []*capture.Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF { break }
+		if err != nil { fmt.Println(err); break }
+		records = append(records, record)
+	}
+	
+	// Select the game server's protocol profile.
+	profile := protocol.Lookup(protocol.DEFAULT_PROFILE)
+	if *mode == "game" && *name == "auto" {
+		profile = selectprofile(records)
+	} else if *mode == "game" {
+		profile = protocol.Lookup(*name)
+		if profile == nil { 
+			fmt.Printf("unknown protocol profile %s\n", *name)
+			os.Exit(2) 
+		}
+	}
+	var cipher security.Cipher = new(security.TQClientCipher)
+	if *mode == "game" { fmt.Printf("Using the %s profile\n", profile.Name) }
+	if *mode == "game" && profile.Cipher == protocol.CIPHER_BLOWFISH { 
+		cipher = new(security.Blowfish) 
+	}
+	sealed := *mode == "game" && profile.Sealed
+	cipher.Init()
+	
+	// Connect to the server and make the key exchange, if the profile has one. 
+	// Then print the server's responses.
+	connection, err := net.Dial("tcp", *host)
+	if err != nil { fmt.Println(err); os.Exit(1) }
+	defer connection.Close()
+	if blowfish, ok := cipher.(*security.Blowfish); ok {
+		response, err := protocol.Respond(connection, blowfish)
+		if err == nil { _, err = connection.Write(response) }
+		if err != nil { fmt.Println("key exchange failed:", err); os.Exit(1) }
+	}
+	done := make(chan struct{})
+	seeds := make(chan uint32, 1)
+	go receive(connection, cipher, sealed, seeds, done)
+	
+	// Send each inbound record to the server. The captured session's password 
+	// seed is taken from the outbound MsgEncryptCode.
+	started := time.Now()
+	sent := 0
+	captured, seed := uint32(0), ^uint32(0)
+	for _, record := range records {
+		if len(record.Data) < 4 { continue }
+		if record.Direction == capture.OUTBOUND {
+			packet := new(packets.MsgEncryptCode)
+			if binary.LittleEndian.Uint16(record.Data[2:4]) == 
+				packets.MSGENCRYPTCODE && 
+				packets.Read(bytes.NewBuffer(record.Data), packet) == nil {
+				captured = packet.Seed
+			}
+			continue
+		}
+		
+		// Wait until the record's offset in the session.
+		if *speed > 0 {
+			offset := time.Duration(float64(record.Offset) / *speed)
+			time.Sleep(offset - time.Since(started))
+		}
+		identifier := binary.LittleEndian.Uint16(record.Data[2:4])
+		fmt.Printf("-> %5d, length %d\n", identifier, len(record.Data))
+		
+		// Encrypt and send the packet, with the client's seal if the profile is 
+		// sealed. Re-key after MsgConnect in game mode.
+		buffer := append([]byte(nil), record.Data...)
+		if *mode == "account" && identifier == packets.MSGACCOUNT && 
+			len(buffer) >= ACCOUNT_PASSWORD + 16 {
+			
+			// Wait for the replayed server's seed, which is sent on connect. 
+			// Servers which don't use seeds won't send one.
+			if seed == ^uint32(0) {
+				select {
+				case seed = <-seeds:
+				case <-time.After(time.Second): seed = 0
+				}
+			}
+			password := buffer[ACCOUNT_PASSWORD:ACCOUNT_PASSWORD + 16]
+			passwordcipher(captured).Decrypt(password)
+			passwordcipher(seed).Encrypt(password)
+		}
+		if sealed { buffer = append(buffer, protocol.SEAL_CLIENT...) }
+		cipher.Encrypt(buffer)
+		if _, err := connection.Write(buffer); err != nil { 
+			fmt.Println(err)
+			break 
+		}
+		sent++
+		if *mode == "game" && identifier == packets.MSGCONNECT {
+			packet := new(packets.MsgConnect)
+			if err := packets.Read(bytes.NewBuffer(record.Data), packet); err == nil {
+				cipher.Generate(packet.Token, packet.Identity)
+			}
+		}
+	}
+	
+	// Give the server a moment to respond before disconnecting.
+	fmt.Printf("Replayed %d packets\n", sent)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+	}
+}
+
+// selectprofile returns the profile for the version in the first inbound 
+// MsgConnect, or the default profile if there isn't one.
+func selectprofile(records []*capture.Record) *protocol.Profile {
+	for _, record := range records {
+		if record.Direction != capture.INBOUND || len(record.Data) < 4 || 
+			binary.LittleEndian.Uint16(record.Data[2:4]) != packets.MSGCONNECT {
+			continue
+		}
+		packet := new(packets.MsgConnect)
+		if err := packets.Read(bytes.NewBuffer(record.Data), packet); err != nil {
+			break
+		}
+		profile, _, err := protocol.Select(packet.Version)
+		if err != nil { fmt.Println(err); break }
+		return profile
+	}
+	return protocol.Lookup(protocol.DEFAULT_PROFILE)
+}
+
+// passwordcipher returns the RC5 cipher for the password in MsgAccount, keyed 
+// with a seed from MsgEncryptCode, or the default key if the seed is zero.
+func passwordcipher(seed uint32) *security.RC5 {
+	cipher := new(security.RC5)
+	if seed != 0 { cipher.Seed(seed) } else { cipher.Init() }
+	return cipher
+}
+
+// receive reads packets from the server until the connection is closed, and 
+// prints the identifier and length of each packet. If the profile is sealed, 
+// the server's seal is read after each packet. The seed from MsgEncryptCode is 
+// passed to the seeds channel.
+func receive(connection net.Conn, cipher security.Cipher, sealed bool, 
+	seeds chan uint32, done chan struct{}) {
+	
+	defer close(done)
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(connection, header); err != nil { 
+			fmt.Println("Disconnected from server")
+			return 
+		}
+		cipher.Decrypt(header)
+		length := int(binary.LittleEndian.Uint16(header[0:2]))
+		if length < 4 { fmt.Println("invalid packet length"); return }
+		body := make([]byte, length - 4)
+		if sealed { body = make([]byte, length - 4 + len(protocol.SEAL_SERVER)) }
+		if _, err := io.ReadFull(connection, body); err != nil { return }
+		cipher.Decrypt(body)
+		identifier := binary.LittleEndian.Uint16(header[2:4])
+		if identifier == packets.MSGENCRYPTCODE {
+			packet := new(packets.MsgEncryptCode)
+			data := append(header, body[:length - 4]...)
+			if packets.Read(bytes.NewBuffer(data), packet) == nil {
+				select {
+				case seeds <- packet.Seed:
+				default:
+				}
+			}
+		}
+		fmt.Printf("<- %5d, length %d\n", identifier, length)
+	}
+}